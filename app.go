@@ -4,25 +4,21 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 
 	"path/filepath"
-	"regexp"
 
 	"spotiflac/backend"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
-var isrcRegex = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}\d{2}\d{5}$`)
-
-func isValidISRC(isrc string) bool {
-	return isrcRegex.MatchString(isrc)
-}
-
 type App struct {
 	ctx context.Context
 
@@ -31,6 +27,17 @@ type App struct {
 	
 	// MPV player for native audio playback
 	mpvPlayer backend.MPVPlayer
+
+	// worker pool that runs queued downloads
+	downloads *backend.DownloadManager
+
+	// checks watched artists for new releases
+	releases *backend.ReleaseWatcher
+
+	// cancels the running batch analysis, if any
+	analysisMu     sync.Mutex
+	analysisCancel context.CancelFunc
+	analysisRun    int
 }
 
 func NewApp() *App {
@@ -45,6 +52,11 @@ func (a *App) startup(ctx context.Context) {
 		fmt.Printf("Failed to init history DB: %v\n", err)
 	}
 
+	// Restore the download queue from the last session
+	if err := backend.LoadDownloadQueue(); err != nil {
+		fmt.Printf("Failed to restore download queue: %v\n", err)
+	}
+
 	// Start local HTTP stream proxy server (used by the frontend <audio> element)
 	// It binds to 127.0.0.1 on an ephemeral port and supports range requests for seeking.
 	a.stream = backend.NewStreamServer()
@@ -52,10 +64,23 @@ func (a *App) startup(ctx context.Context) {
 
 	// Initialize MPV player for native audio playback
 	a.mpvPlayer = backend.NewMPVPlayer()
+
+	// Start the download worker pool
+	a.downloads = backend.NewDownloadManager(backend.DefaultDownloadManagerConfig())
+
+	// Start checking watched artists for new releases
+	a.releases = backend.NewReleaseWatcher(a.handleNewReleases)
+	a.releases.Start()
 }
 
 func (a *App) shutdown(ctx context.Context) {
 	// best-effort cleanup
+	if a.releases != nil {
+		a.releases.Stop()
+	}
+	if a.downloads != nil {
+		a.downloads.Stop()
+	}
 	if a.mpvPlayer != nil {
 		a.mpvPlayer.Close()
 	}
@@ -104,15 +129,34 @@ type DownloadRequest struct {
 	PlaylistName         string `json:"playlist_name,omitempty"`
 	PlaylistOwner        string `json:"playlist_owner,omitempty"`
 	AllowFallback        bool   `json:"allow_fallback"`
+	// ProviderOrder is the order auto mode tries providers in. Empty uses
+	// backend.DefaultProviderOrder.
+	ProviderOrder []string `json:"provider_order,omitempty"`
+	// ProviderPolicy picks how auto mode orders providers: "ordered"
+	// (default), "highest_quality" or "fastest".
+	ProviderPolicy string `json:"provider_policy,omitempty"`
+	// MinQuality is checked against the downloaded file's STREAMINFO.
+	MinQuality backend.QualityFloor `json:"min_quality,omitempty"`
+	// Verify decodes the finished file and compares it with Duration.
+	Verify backend.Verification `json:"verify,omitempty"`
+	// ReplayGain tags the files with track and album gain once every
+	// request in the same DownloadTracks batch has finished.
+	ReplayGain bool `json:"replay_gain,omitempty"`
 }
 
 type DownloadResponse struct {
-	Success       bool   `json:"success"`
-	Message       string `json:"message"`
-	File          string `json:"file,omitempty"`
-	Error         string `json:"error,omitempty"`
-	AlreadyExists bool   `json:"already_exists,omitempty"`
-	ItemID        string `json:"item_id,omitempty"`
+	Success       bool                      `json:"success"`
+	Message       string                    `json:"message"`
+	File          string                    `json:"file,omitempty"`
+	Error         string                    `json:"error,omitempty"`
+	AlreadyExists bool                      `json:"already_exists,omitempty"`
+	ItemID        string                    `json:"item_id,omitempty"`
+	Provider      string                    `json:"provider,omitempty"`
+	Attempts      []backend.ProviderAttempt `json:"attempts,omitempty"`
+	Downgraded    bool                      `json:"downgraded,omitempty"`
+	QualityNote   string                    `json:"quality_note,omitempty"`
+	Unverified    bool                      `json:"unverified,omitempty"`
+	VerifyNote    string                    `json:"verify_note,omitempty"`
 }
 
 func (a *App) GetStreamingURLs(spotifyTrackID string, region string) (string, error) {
@@ -213,6 +257,8 @@ func (a *App) SearchSpotifyByType(req SpotifySearchByTypeRequest) ([]backend.Sea
 
 func (a *App) DownloadTrack(req DownloadRequest) (DownloadResponse, error) {
 
+	rawRequest, _ := json.Marshal(req)
+
 	if req.Service == "qobuz" && req.ISRC == "" && req.SpotifyID == "" {
 		return DownloadResponse{
 			Success: false,
@@ -249,27 +295,39 @@ func (a *App) DownloadTrack(req DownloadRequest) (DownloadResponse, error) {
 
 	itemID := req.ItemID
 	if itemID == "" {
+		itemID = newDownloadItemID(req)
+		backend.AddToQueue(itemID, req.TrackName, req.ArtistName, req.AlbumName, req.SpotifyID)
+	}
+	backend.SetDownloadItemRequest(itemID, string(rawRequest))
 
-		if req.SpotifyID != "" {
-			itemID = fmt.Sprintf("%s-%d", req.SpotifyID, time.Now().UnixNano())
-		} else {
-			itemID = fmt.Sprintf("%s-%s-%d", req.TrackName, req.ArtistName, time.Now().UnixNano())
-		}
+	// The download is registered before the item is started, so a cancel or
+	// pause arriving in between stops it through downloadCtx.
+	downloadCtx, finishDownload := backend.BeginDownloadItem(itemID)
+	defer finishDownload()
 
-		backend.AddToQueue(itemID, req.TrackName, req.ArtistName, req.AlbumName, req.SpotifyID)
+	if !backend.StartDownloadItem(itemID) {
+		item, _ := backend.GetDownloadItem(itemID)
+		err := fmt.Errorf("download item is not queued: %s", item.Status)
+		return DownloadResponse{
+			Success: false,
+			Error:   err.Error(),
+			ItemID:  itemID,
+		}, err
+	}
+	if backend.FinishStoppedDownloadItem(downloadCtx, itemID) {
+		stopErr := context.Cause(downloadCtx)
+		return DownloadResponse{
+			Success: false,
+			Error:   stopErr.Error(),
+			ItemID:  itemID,
+		}, stopErr
 	}
 
 	backend.SetDownloading(true)
-	backend.StartDownloadItem(itemID)
 	defer backend.SetDownloading(false)
 
-	spotifyURL := ""
-	if req.SpotifyID != "" {
-		spotifyURL = fmt.Sprintf("https://open.spotify.com/track/%s", req.SpotifyID)
-	}
-
 	if req.SpotifyID != "" && (req.Copyright == "" || req.Publisher == "" || req.SpotifyTotalDiscs == 0 || req.ReleaseDate == "" || req.SpotifyTotalTracks == 0 || req.SpotifyTrackNumber == 0) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(downloadCtx, 10*time.Second)
 		defer cancel()
 
 		trackURL := fmt.Sprintf("https://open.spotify.com/track/%s", req.SpotifyID)
@@ -329,98 +387,39 @@ func (a *App) DownloadTrack(req DownloadRequest) (DownloadResponse, error) {
 		}
 	}
 
-	switch req.Service {
-	case "amazon":
-		downloader := backend.NewAmazonDownloader()
-		if req.ServiceURL != "" {
-			filename, err = downloader.DownloadByURL(req.ServiceURL, req.OutputDir, req.AudioFormat, req.FilenameFormat, req.PlaylistName, req.PlaylistOwner, req.TrackNumber, req.Position, req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist, req.ReleaseDate, req.CoverURL, req.SpotifyTrackNumber, req.SpotifyDiscNumber, req.SpotifyTotalTracks, req.EmbedMaxQualityCover, req.SpotifyTotalDiscs, req.Copyright, req.Publisher, spotifyURL)
-		} else {
-			if req.SpotifyID == "" {
-				return DownloadResponse{
-					Success: false,
-					Error:   "Spotify ID is required for Amazon Music",
-				}, fmt.Errorf("spotify ID is required for Amazon Music")
-			}
-			filename, err = downloader.DownloadBySpotifyID(req.SpotifyID, req.OutputDir, req.AudioFormat, req.FilenameFormat, req.PlaylistName, req.PlaylistOwner, req.TrackNumber, req.Position, req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist, req.ReleaseDate, req.CoverURL, req.SpotifyTrackNumber, req.SpotifyDiscNumber, req.SpotifyTotalTracks, req.EmbedMaxQualityCover, req.SpotifyTotalDiscs, req.Copyright, req.Publisher, spotifyURL)
-		}
-
-	case "tidal":
-		if req.ApiURL == "" || req.ApiURL == "auto" {
-			downloader := backend.NewTidalDownloader("")
-			if req.ServiceURL != "" {
-				filename, err = downloader.DownloadByURLWithFallback(req.ServiceURL, req.OutputDir, req.AudioFormat, req.FilenameFormat, req.TrackNumber, req.Position, req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist, req.ReleaseDate, req.UseAlbumTrackNumber, req.CoverURL, req.EmbedMaxQualityCover, req.SpotifyTrackNumber, req.SpotifyDiscNumber, req.SpotifyTotalTracks, req.SpotifyTotalDiscs, req.Copyright, req.Publisher, spotifyURL, req.AllowFallback)
-			} else {
-				if req.SpotifyID == "" {
-					return DownloadResponse{
-						Success: false,
-						Error:   "Spotify ID is required for Tidal",
-					}, fmt.Errorf("spotify ID is required for Tidal")
-				}
-				filename, err = downloader.Download(req.SpotifyID, req.OutputDir, req.AudioFormat, req.FilenameFormat, req.TrackNumber, req.Position, req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist, req.ReleaseDate, req.UseAlbumTrackNumber, req.CoverURL, req.EmbedMaxQualityCover, req.SpotifyTrackNumber, req.SpotifyDiscNumber, req.SpotifyTotalTracks, req.SpotifyTotalDiscs, req.Copyright, req.Publisher, spotifyURL, req.AllowFallback)
-			}
-		} else {
-			downloader := backend.NewTidalDownloader(req.ApiURL)
-			if req.ServiceURL != "" {
-				filename, err = downloader.DownloadByURL(req.ServiceURL, req.OutputDir, req.AudioFormat, req.FilenameFormat, req.TrackNumber, req.Position, req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist, req.ReleaseDate, req.UseAlbumTrackNumber, req.CoverURL, req.EmbedMaxQualityCover, req.SpotifyTrackNumber, req.SpotifyDiscNumber, req.SpotifyTotalTracks, req.SpotifyTotalDiscs, req.Copyright, req.Publisher, spotifyURL, req.AllowFallback)
-			} else {
-				if req.SpotifyID == "" {
-					return DownloadResponse{
-						Success: false,
-						Error:   "Spotify ID is required for Tidal",
-					}, fmt.Errorf("spotify ID is required for Tidal")
-				}
-				filename, err = downloader.Download(req.SpotifyID, req.OutputDir, req.AudioFormat, req.FilenameFormat, req.TrackNumber, req.Position, req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist, req.ReleaseDate, req.UseAlbumTrackNumber, req.CoverURL, req.EmbedMaxQualityCover, req.SpotifyTrackNumber, req.SpotifyDiscNumber, req.SpotifyTotalTracks, req.SpotifyTotalDiscs, req.Copyright, req.Publisher, spotifyURL, req.AllowFallback)
-			}
-		}
-
-	case "qobuz":
-		downloader := backend.NewQobuzDownloader()
-
-		quality := req.AudioFormat
-		if quality == "" {
-			quality = "6"
-		}
-
-		deezerISRC := req.ISRC
-
-		if len(deezerISRC) != 12 || !isValidISRC(deezerISRC) {
-			deezerISRC = ""
+	provider := req.Service
+	var attempts []backend.ProviderAttempt
+	if req.Service == "auto" {
+		var result *backend.FallbackResult
+		result, err = backend.DownloadWithFallback(downloadCtx, backend.ProviderOrder("auto", req.ProviderOrder), req.ProviderPolicy, req.trackRequest(), req.downloadOptions())
+		filename, provider, attempts = result.Path, result.Provider, result.Attempts
+	} else {
+		filename, err = backend.DownloadWithProvider(downloadCtx, req.Service, req.trackRequest(), req.downloadOptions())
+
+		var verifyErr *backend.VerificationError
+		if err != nil && req.Verify.RetryOtherProviders && errors.As(err, &verifyErr) && downloadCtx.Err() == nil {
+			fmt.Printf("%s failed verification, trying other providers\n", req.Service)
+			attempts = []backend.ProviderAttempt{{Provider: req.Service, Error: err.Error()}}
+			others := backend.ProviderOrder(req.Service, req.ProviderOrder)[1:]
+
+			var result *backend.FallbackResult
+			result, err = backend.DownloadWithFallback(downloadCtx, others, req.ProviderPolicy, req.trackRequest(), req.downloadOptions())
+			filename, provider = result.Path, result.Provider
+			attempts = append(attempts, result.Attempts...)
 		}
+	}
 
-		if deezerISRC == "" && req.SpotifyID != "" {
-
-			songlinkClient := backend.NewSongLinkClient()
-			deezerURL, err := songlinkClient.GetDeezerURLFromSpotify(req.SpotifyID)
-			if err != nil {
-				return DownloadResponse{
-					Success: false,
-					Error:   fmt.Sprintf("Failed to get Deezer URL: %v", err),
-				}, err
-			}
-			deezerISRC, err = backend.GetDeezerISRC(deezerURL)
-			if err != nil {
-				return DownloadResponse{
-					Success: false,
-					Error:   fmt.Sprintf("Failed to get ISRC from Deezer: %v", err),
-				}, err
-			}
-		}
-		if deezerISRC == "" {
+	if err != nil {
+		if backend.FinishStoppedDownloadItem(downloadCtx, itemID) {
+			stopErr := context.Cause(downloadCtx)
 			return DownloadResponse{
-				Success: false,
-				Error:   "ISRC is required for Qobuz (could not fetch from Deezer)",
-			}, fmt.Errorf("ISRC is required for Qobuz")
+				Success:  false,
+				Error:    stopErr.Error(),
+				ItemID:   itemID,
+				Attempts: attempts,
+			}, stopErr
 		}
-		filename, err = downloader.DownloadByISRC(deezerISRC, req.OutputDir, quality, req.FilenameFormat, req.TrackNumber, req.Position, req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist, req.ReleaseDate, req.UseAlbumTrackNumber, req.CoverURL, req.EmbedMaxQualityCover, req.SpotifyTrackNumber, req.SpotifyDiscNumber, req.SpotifyTotalTracks, req.SpotifyTotalDiscs, req.Copyright, req.Publisher, spotifyURL, req.AllowFallback)
 
-	default:
-		return DownloadResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Unknown service: %s", req.Service),
-		}, fmt.Errorf("unknown service: %s", req.Service)
-	}
-
-	if err != nil {
 		backend.FailDownloadItem(itemID, fmt.Sprintf("Download failed: %v", err))
 
 		if filename != "" && !strings.HasPrefix(filename, "EXISTS:") {
@@ -434,9 +433,10 @@ func (a *App) DownloadTrack(req DownloadRequest) (DownloadResponse, error) {
 		}
 
 		return DownloadResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Download failed: %v", err),
-			ItemID:  itemID,
+			Success:  false,
+			Error:    fmt.Sprintf("Download failed: %v", err),
+			ItemID:   itemID,
+			Attempts: attempts,
 		}, err
 	}
 
@@ -495,6 +495,26 @@ func (a *App) DownloadTrack(req DownloadRequest) (DownloadResponse, error) {
 		}(filename, req.SpotifyID, req.TrackName, req.ArtistName)
 	}
 
+	// A fail-mode floor was already enforced by the provider run; a flag-mode
+	// floor only marks the item.
+	qualityNote := ""
+	if !alreadyExists && req.MinQuality.OnViolation == backend.QualityFloorFlag {
+		note, checkErr := backend.CheckQualityFloor(filename, req.MinQuality)
+		if checkErr != nil {
+			note = checkErr.Error()
+		}
+		qualityNote = note
+	}
+	downgraded := qualityNote != ""
+
+	// Likewise, fail-mode verification already ran; flag mode only marks the
+	// item.
+	verifyNote := ""
+	if !alreadyExists && req.Verify.Enabled && req.Verify.OnMismatch == backend.VerificationFlag && strings.HasSuffix(strings.ToLower(filename), ".flac") {
+		verifyNote = backend.CheckVerification(filename, req.Duration*1000, req.Verify)
+	}
+	unverified := verifyNote != ""
+
 	message := "Download completed successfully"
 	if alreadyExists {
 		message = "File already exists"
@@ -509,7 +529,18 @@ func (a *App) DownloadTrack(req DownloadRequest) (DownloadResponse, error) {
 			backend.CompleteDownloadItem(itemID, filename, 0)
 		}
 
-		go func(fPath, track, artist, album, sID, cover, format string) {
+		if downgraded {
+			fmt.Printf("Download is below minimum quality (%s), keeping it flagged as downgraded\n", qualityNote)
+			backend.FlagDownloadItemDowngraded(itemID, qualityNote)
+			message = "Download completed below minimum quality"
+		}
+		if unverified {
+			fmt.Printf("Download failed verification (%s), keeping it flagged as unverified\n", verifyNote)
+			backend.FlagDownloadItemUnverified(itemID, verifyNote)
+			message = "Download completed but failed verification"
+		}
+
+		go func(id, fPath, track, artist, album, sID, cover, format, provider string, downgraded, unverified bool) {
 			quality := "Unknown"
 			durationStr := "--:--"
 
@@ -532,6 +563,29 @@ func (a *App) DownloadTrack(req DownloadRequest) (DownloadResponse, error) {
 				Quality:     quality,
 				Format:      format,
 				Path:        fPath,
+				Provider:    provider,
+				Downgraded:  downgraded,
+				Unverified:  unverified,
+			}
+
+			if strings.HasSuffix(strings.ToLower(fPath), ".flac") {
+				if scan, err := backend.ScanDownload(fPath); err != nil {
+					fmt.Printf("Failed to scan %s: %v\n", fPath, err)
+				} else {
+					if verdict := scan.Transcode; verdict != nil && verdict.LikelyLossy {
+						fmt.Printf("%s looks transcoded from a lossy source (%s, cutoff %.1fkHz)\n", fPath, verdict.LikelySource, verdict.CutoffFreq/1000)
+						item.LossySource = verdict.LikelySource
+					}
+					if effective := scan.Effective; effective.IsFake() {
+						fmt.Printf("%s is not really %s (%s)\n", fPath, quality, effective.Description)
+						item.Effective = effective.Description
+						backend.FlagDownloadItemFakeHiRes(id, effective.Description)
+					}
+					if report := scan.Defects; report != nil && len(report.Issues) > 0 {
+						fmt.Printf("%s has defects: %s\n", fPath, strings.Join(report.Issues, ", "))
+						item.Defects = report.Issues
+					}
+				}
 			}
 
 			if item.Format == "" || item.Format == "LOSSLESS" {
@@ -542,12 +596,12 @@ func (a *App) DownloadTrack(req DownloadRequest) (DownloadResponse, error) {
 			}
 
 			switch item.Format {
-			case "6", "7", "27":
+			case "6", "7", "27", "16", "24":
 				item.Format = "FLAC"
 			}
 
 			backend.AddHistoryItem(item, "SpotiFLAC")
-		}(filename, req.TrackName, req.ArtistName, req.AlbumName, req.SpotifyID, req.CoverURL, req.AudioFormat)
+		}(itemID, filename, req.TrackName, req.ArtistName, req.AlbumName, req.SpotifyID, req.CoverURL, req.AudioFormat, provider, downgraded, unverified)
 	}
 
 	return DownloadResponse{
@@ -556,9 +610,567 @@ func (a *App) DownloadTrack(req DownloadRequest) (DownloadResponse, error) {
 		File:          filename,
 		AlreadyExists: alreadyExists,
 		ItemID:        itemID,
+		Provider:      provider,
+		Attempts:      attempts,
+		Downgraded:    downgraded,
+		QualityNote:   qualityNote,
+		Unverified:    unverified,
+		VerifyNote:    verifyNote,
 	}, nil
 }
 
+func (req DownloadRequest) trackRequest() backend.TrackRequest {
+	return backend.TrackRequest{
+		SpotifyID:   req.SpotifyID,
+		ISRC:        req.ISRC,
+		ServiceURL:  req.ServiceURL,
+		TrackName:   req.TrackName,
+		ArtistName:  req.ArtistName,
+		AlbumName:   req.AlbumName,
+		AlbumArtist: req.AlbumArtist,
+		ReleaseDate: req.ReleaseDate,
+		CoverURL:    req.CoverURL,
+		TrackNumber: req.SpotifyTrackNumber,
+		DiscNumber:  req.SpotifyDiscNumber,
+		TotalTracks: req.SpotifyTotalTracks,
+		TotalDiscs:  req.SpotifyTotalDiscs,
+		Copyright:   req.Copyright,
+		Publisher:   req.Publisher,
+		DurationMS:  req.Duration * 1000,
+	}
+}
+
+func (req DownloadRequest) downloadOptions() backend.DownloadOptions {
+	return backend.DownloadOptions{
+		OutputDir:            req.OutputDir,
+		Quality:              req.AudioFormat,
+		FilenameFormat:       req.FilenameFormat,
+		PlaylistName:         req.PlaylistName,
+		PlaylistOwner:        req.PlaylistOwner,
+		IncludeTrackNumber:   req.TrackNumber,
+		Position:             req.Position,
+		UseAlbumTrackNumber:  req.UseAlbumTrackNumber,
+		EmbedMaxQualityCover: req.EmbedMaxQualityCover,
+		AllowFallback:        req.AllowFallback,
+		ApiURL:               req.ApiURL,
+		MinQuality:           req.MinQuality,
+		Verify:               req.Verify,
+	}
+}
+
+func newDownloadItemID(req DownloadRequest) string {
+	if req.SpotifyID != "" {
+		return fmt.Sprintf("%s-%d", req.SpotifyID, time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%s-%s-%d", req.TrackName, req.ArtistName, time.Now().UnixNano())
+}
+
+// ProbeTrackQuality asks every provider in the request's provider order for
+// the best quality it can deliver for the track, without downloading it.
+func (a *App) ProbeTrackQuality(req DownloadRequest) ([]backend.ProviderProbe, error) {
+	if req.SpotifyID == "" && req.ISRC == "" {
+		return nil, fmt.Errorf("spotify ID or ISRC is required")
+	}
+	return backend.ProbeProviders(a.ctx, backend.ProviderOrder("auto", req.ProviderOrder), req.trackRequest()), nil
+}
+
+func (a *App) downloadJob(req DownloadRequest) *backend.DownloadJob {
+	service := req.Service
+	if service == "" {
+		service = "tidal"
+	}
+	return &backend.DownloadJob{
+		ItemID:  req.ItemID,
+		Service: service,
+		Run: func() error {
+			_, err := a.DownloadTrack(req)
+			return err
+		},
+	}
+}
+
+// DownloadTracks queues a batch of downloads on the backend worker pool and
+// returns their queue item IDs in request order.
+func (a *App) DownloadTracks(reqs []DownloadRequest) ([]string, error) {
+	return a.queueDownloads(reqs, nil)
+}
+
+// queueDownloads does the work of DownloadTracks. done, if set, is called
+// after each job leaves the worker pool.
+func (a *App) queueDownloads(reqs []DownloadRequest, done func()) ([]string, error) {
+	if a.downloads == nil {
+		return nil, fmt.Errorf("download manager not initialized")
+	}
+
+	itemIDs := make([]string, 0, len(reqs))
+	jobs := make([]*backend.DownloadJob, 0, len(reqs))
+	for _, req := range reqs {
+		if req.ItemID == "" {
+			req.ItemID = newDownloadItemID(req)
+			backend.AddToQueue(req.ItemID, req.TrackName, req.ArtistName, req.AlbumName, req.SpotifyID)
+		}
+		rawRequest, _ := json.Marshal(req)
+		backend.SetDownloadItemRequest(req.ItemID, string(rawRequest))
+
+		itemIDs = append(itemIDs, req.ItemID)
+		jobs = append(jobs, a.downloadJob(req))
+	}
+
+	var replayGainIDs []string
+	var replayGainJobs []*backend.DownloadJob
+	for i, req := range reqs {
+		if req.ReplayGain {
+			replayGainIDs = append(replayGainIDs, itemIDs[i])
+			replayGainJobs = append(replayGainJobs, jobs[i])
+		}
+	}
+	if len(replayGainJobs) > 0 {
+		var remaining atomic.Int32
+		remaining.Store(int32(len(replayGainJobs)))
+		for _, job := range replayGainJobs {
+			job.Done = func() {
+				if remaining.Add(-1) == 0 {
+					backend.ApplyReplayGainToItems(replayGainIDs)
+				}
+			}
+		}
+	}
+	if done != nil {
+		for _, job := range jobs {
+			next := job.Done
+			job.Done = func() {
+				if next != nil {
+					next()
+				}
+				done()
+			}
+		}
+	}
+
+	if err := a.downloads.Submit(jobs...); err != nil {
+		return nil, err
+	}
+	return itemIDs, nil
+}
+
+// DownloadCollection fetches an album, playlist or track URL, queues one
+// download per track and returns the collection job. options is the template
+// for every request; its per-track fields are filled from the metadata.
+// Progress is emitted as "collection:progress" after each track and can be
+// polled with GetCollectionProgress.
+func (a *App) DownloadCollection(spotifyURL string, options DownloadRequest) (*backend.CollectionJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	defer cancel()
+	return a.downloadCollection(ctx, spotifyURL, options)
+}
+
+func (a *App) downloadCollection(ctx context.Context, spotifyURL string, options DownloadRequest) (*backend.CollectionJob, error) {
+	if spotifyURL == "" {
+		return nil, fmt.Errorf("URL parameter is required")
+	}
+
+	collection, err := backend.NewSpotifyMetadataClient().FetchCollection(ctx, spotifyURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch metadata: %v", err)
+	}
+	if len(collection.Tracks) == 0 {
+		return nil, fmt.Errorf("no tracks found in %s", collection.Name)
+	}
+	return a.queueCollection(spotifyURL, collection, options)
+}
+
+// queueCollection queues the tracks of collection and registers them as one
+// collection job.
+func (a *App) queueCollection(spotifyURL string, collection *backend.Collection, options DownloadRequest) (*backend.CollectionJob, error) {
+	// Jobs can finish before the collection is registered, so their progress
+	// events wait for it.
+	var job *backend.CollectionJob
+	registered := make(chan struct{})
+	emitProgress := func() {
+		<-registered
+		if job == nil {
+			return
+		}
+		if progress, ok := backend.GetCollectionProgress(job.ID); ok {
+			runtime.EventsEmit(a.ctx, "collection:progress", progress)
+		}
+	}
+
+	itemIDs, err := a.queueDownloads(collectionRequests(collection, options), emitProgress)
+	if err == nil {
+		job = backend.NewCollectionJob(spotifyURL, collection, itemIDs)
+	}
+	close(registered)
+	if err != nil {
+		return nil, err
+	}
+
+	emitProgress()
+	return job, nil
+}
+
+// collectionRequests builds one request per track of collection.
+func collectionRequests(collection *backend.Collection, options DownloadRequest) []DownloadRequest {
+	outputDir := options.OutputDir
+	if collection.Type == backend.CollectionAlbum && outputDir != "" {
+		outputDir = filepath.Join(outputDir, backend.SanitizeFilename(collection.Name))
+	}
+
+	reqs := make([]DownloadRequest, 0, len(collection.Tracks))
+	for i, track := range collection.Tracks {
+		req := options
+		req.ItemID = ""
+		req.OutputDir = outputDir
+		req.ISRC = track.ISRC
+		req.SpotifyID = track.SpotifyID
+		req.TrackName = track.Name
+		req.ArtistName = track.Artists
+		req.AlbumName = track.AlbumName
+		req.AlbumArtist = track.AlbumArtist
+		req.ReleaseDate = track.ReleaseDate
+		req.CoverURL = track.Images
+		req.Duration = track.DurationMS / 1000
+		req.SpotifyTrackNumber = track.TrackNumber
+		req.SpotifyDiscNumber = track.DiscNumber
+		req.SpotifyTotalTracks = track.TotalTracks
+		req.SpotifyTotalDiscs = track.TotalDiscs
+		req.Copyright = track.Copyright
+		req.Publisher = track.Publisher
+		req.Position = track.TrackNumber
+		req.PlaylistName = ""
+		req.PlaylistOwner = ""
+
+		if collection.Type == backend.CollectionPlaylist {
+			req.Position = i + 1
+			req.PlaylistName = collection.Name
+			req.PlaylistOwner = collection.Owner
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+// GetCollectionProgress returns the aggregate status of a collection queued
+// by DownloadCollection.
+func (a *App) GetCollectionProgress(id string) (backend.CollectionProgress, error) {
+	progress, ok := backend.GetCollectionProgress(id)
+	if !ok {
+		return backend.CollectionProgress{}, fmt.Errorf("collection not found: %s", id)
+	}
+	return progress, nil
+}
+
+func (a *App) GetCollectionJobs() []backend.CollectionJob {
+	return backend.GetCollectionJobs()
+}
+
+// PlanDiscography returns what DownloadDiscography would queue for an artist:
+// one edition per release under the given policy, with singles reduced to the
+// tracks not already on an album.
+func (a *App) PlanDiscography(artistURL string, discography backend.DiscographyOptions) (*backend.DiscographyPlan, error) {
+	if artistURL == "" {
+		return nil, fmt.Errorf("URL parameter is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	defer cancel()
+
+	return backend.PlanDiscography(ctx, artistURL, discography)
+}
+
+// DownloadDiscography plans an artist's discography and queues every planned
+// release as its own collection, in an album subfolder of the output folder.
+// Releases that fail to queue are logged and left out of the returned jobs.
+func (a *App) DownloadDiscography(artistURL string, discography backend.DiscographyOptions, options DownloadRequest) ([]*backend.CollectionJob, error) {
+	if a.downloads == nil {
+		return nil, fmt.Errorf("download manager not initialized")
+	}
+
+	plan, err := a.PlanDiscography(artistURL, discography)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.Releases) == 0 {
+		return nil, fmt.Errorf("no releases found for %s", plan.Artist)
+	}
+
+	jobs := make([]*backend.CollectionJob, 0, len(plan.Releases))
+	for _, release := range plan.Releases {
+		collection := &backend.Collection{
+			Type:   backend.CollectionAlbum,
+			Name:   release.Release.Name,
+			Tracks: release.Tracks,
+		}
+		job, err := a.queueCollection(release.Release.ExternalURL, collection, options)
+		if err != nil {
+			fmt.Printf("Failed to queue %s: %v\n", release.Release.Name, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("failed to queue any release of %s", plan.Artist)
+	}
+	return jobs, nil
+}
+
+// SaveSyncTarget stores a playlist sync target. options is the request
+// template for the tracks it downloads; its output directory is replaced by
+// the target's.
+func (a *App) SaveSyncTarget(target backend.SyncTarget, options DownloadRequest) (*backend.SyncTarget, error) {
+	rawOptions, err := json.Marshal(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode options: %v", err)
+	}
+	target.Options = rawOptions
+	return backend.SaveSyncTarget(target)
+}
+
+func (a *App) GetSyncTargets() ([]backend.SyncTarget, error) {
+	return backend.GetSyncTargets()
+}
+
+func (a *App) DeleteSyncTarget(id string) error {
+	return backend.DeleteSyncTarget(id)
+}
+
+func (a *App) GetSyncReports(id string) ([]backend.SyncReport, error) {
+	return backend.GetSyncReports(id)
+}
+
+func (a *App) GetPlaylistSnapshots(playlistID string) ([]backend.PlaylistSnapshot, error) {
+	return backend.GetPlaylistSnapshots(playlistID)
+}
+
+func (a *App) GetPlaylistSnapshot(playlistID string, version int) (*backend.PlaylistSnapshot, error) {
+	return backend.GetPlaylistSnapshot(playlistID, version)
+}
+
+func (a *App) DeletePlaylistSnapshots(playlistID string) error {
+	return backend.DeletePlaylistSnapshots(playlistID)
+}
+
+// DiffPlaylistSnapshots compares two stored versions of a playlist. A to of 0
+// is the latest version and a from of 0 the version before to.
+func (a *App) DiffPlaylistSnapshots(playlistID string, from, to int) (*backend.PlaylistDiff, error) {
+	return backend.DiffPlaylistSnapshots(playlistID, from, to)
+}
+
+// SyncPlaylist brings a sync target up to date: tracks removed from the
+// playlist are handled by its remove policy and tracks missing locally are
+// queued. The returned report is the state at queue time; the final one is
+// saved and emitted as "sync:completed" once the downloads are done. Mass
+// removals are held in the report until the sync is run again with
+// confirmRemovals set.
+func (a *App) SyncPlaylist(id string, confirmRemovals bool) (*backend.SyncReport, error) {
+	if a.downloads == nil {
+		return nil, fmt.Errorf("download manager not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	defer cancel()
+
+	run, err := backend.StartPlaylistSync(ctx, id, confirmRemovals)
+	if err != nil {
+		return nil, err
+	}
+
+	var options DownloadRequest
+	if len(run.Target.Options) > 0 {
+		if err := json.Unmarshal(run.Target.Options, &options); err != nil {
+			run.Finish(nil)
+			return nil, fmt.Errorf("failed to decode sync options: %v", err)
+		}
+	}
+	options.OutputDir = run.Target.OutputDir
+
+	// The target folder already is the playlist folder, so the requests must
+	// not add another one named after the playlist.
+	all := collectionRequests(run.Playlist, options)
+	reqs := make([]DownloadRequest, 0, len(run.Missing))
+	for _, i := range run.Missing {
+		req := all[i]
+		req.PlaylistName = ""
+		reqs = append(reqs, req)
+	}
+
+	finish := func(itemIDs []string) {
+		report := run.Finish(itemIDs)
+		runtime.EventsEmit(a.ctx, "sync:completed", report)
+	}
+	if len(reqs) == 0 {
+		finish(nil)
+		return &run.Report, nil
+	}
+
+	var itemIDs []string
+	var remaining atomic.Int32
+	remaining.Store(int32(len(reqs)))
+	queued := make(chan struct{})
+	itemIDs, err = a.queueDownloads(reqs, func() {
+		if remaining.Add(-1) == 0 {
+			<-queued
+			finish(itemIDs)
+		}
+	})
+	report := run.Report
+	close(queued)
+	if err != nil {
+		run.Finish(nil)
+		return nil, err
+	}
+	return &report, nil
+}
+
+// WatchArtist adds an artist URL or ID to the release watch list. Releases
+// out at this point are the baseline and are not reported.
+func (a *App) WatchArtist(artist string) (*backend.WatchedArtist, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	watched, err := backend.WatchArtist(ctx, artist)
+	if err != nil {
+		return nil, err
+	}
+	if a.releases != nil {
+		a.releases.Reschedule()
+	}
+	return watched, nil
+}
+
+func (a *App) UnwatchArtist(id string) error {
+	return backend.UnwatchArtist(id)
+}
+
+func (a *App) GetWatchedArtists() ([]backend.WatchedArtist, error) {
+	return backend.GetWatchedArtists()
+}
+
+func (a *App) GetReleaseWatchConfig() backend.ReleaseWatchConfig {
+	return backend.GetReleaseWatchConfig()
+}
+
+// SetReleaseWatchConfig saves the watcher settings. options is the request
+// template new releases are queued with when auto-download is on.
+func (a *App) SetReleaseWatchConfig(config backend.ReleaseWatchConfig, options DownloadRequest) error {
+	rawOptions, err := json.Marshal(options)
+	if err != nil {
+		return fmt.Errorf("failed to encode options: %v", err)
+	}
+	config.Options = rawOptions
+	if err := backend.SaveReleaseWatchConfig(config); err != nil {
+		return err
+	}
+	if a.releases != nil {
+		a.releases.Reschedule()
+	}
+	return nil
+}
+
+// CheckReleasesNow checks every watched artist right away and returns the
+// new releases found.
+func (a *App) CheckReleasesNow() ([]backend.NewRelease, error) {
+	if a.releases == nil {
+		return nil, fmt.Errorf("release watcher not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	return a.releases.CheckNow(ctx)
+}
+
+func (a *App) GetNewReleases() ([]backend.NewRelease, error) {
+	return backend.GetNewReleases()
+}
+
+// DismissNewReleases clears the given release IDs from the new releases
+// list, or the whole list when ids is empty.
+func (a *App) DismissNewReleases(ids []string) error {
+	return backend.DismissNewReleases(ids)
+}
+
+// handleNewReleases queues new releases when auto-download is on and emits
+// them as "releases:new". ctx is the watcher's, so stopping it also stops the
+// metadata fetches behind the queueing.
+func (a *App) handleNewReleases(ctx context.Context, releases []backend.NewRelease) {
+	config := backend.GetReleaseWatchConfig()
+	if config.AutoDownload {
+		var options DownloadRequest
+		var optionsErr error
+		if len(config.Options) > 0 {
+			optionsErr = json.Unmarshal(config.Options, &options)
+		}
+
+		for i := range releases {
+			if optionsErr != nil {
+				releases[i].QueueError = fmt.Sprintf("failed to decode download options: %v", optionsErr)
+				continue
+			}
+			fetchCtx, cancel := context.WithTimeout(ctx, 300*time.Second)
+			job, err := a.downloadCollection(fetchCtx, releases[i].Release.ExternalURL, options)
+			cancel()
+			if err != nil {
+				releases[i].QueueError = err.Error()
+				continue
+			}
+			releases[i].CollectionID = job.ID
+		}
+		if err := backend.SaveNewReleases(releases); err != nil {
+			fmt.Printf("Failed to save new releases: %v\n", err)
+		}
+	}
+
+	runtime.EventsEmit(a.ctx, "releases:new", releases)
+}
+
+// ResumeInterruptedDownloads requeues every item that was interrupted by an
+// app exit and hands it back to the worker pool.
+func (a *App) ResumeInterruptedDownloads() ([]string, error) {
+	if a.downloads == nil {
+		return nil, fmt.Errorf("download manager not initialized")
+	}
+
+	var itemIDs []string
+	var jobs []*backend.DownloadJob
+	for _, item := range backend.GetDownloadQueue().Queue {
+		if item.Status != backend.StatusInterrupted || item.Request == "" {
+			continue
+		}
+
+		var req DownloadRequest
+		if err := json.Unmarshal([]byte(item.Request), &req); err != nil {
+			backend.FailDownloadItem(item.ID, fmt.Sprintf("Failed to decode stored request: %v", err))
+			continue
+		}
+		if !backend.RequeueDownloadItem(item.ID) {
+			continue
+		}
+
+		req.ItemID = item.ID
+		itemIDs = append(itemIDs, item.ID)
+		jobs = append(jobs, a.downloadJob(req))
+	}
+
+	if err := a.downloads.Submit(jobs...); err != nil {
+		return nil, err
+	}
+	return itemIDs, nil
+}
+
+func (a *App) GetDownloadManagerConfig() backend.DownloadManagerConfig {
+	if a.downloads == nil {
+		return backend.DefaultDownloadManagerConfig()
+	}
+	return a.downloads.Config()
+}
+
+func (a *App) SetDownloadManagerConfig(config backend.DownloadManagerConfig) {
+	if a.downloads == nil {
+		return
+	}
+	a.downloads.SetConfig(config)
+}
+
 func (a *App) OpenFolder(path string) error {
 	if path == "" {
 		return fmt.Errorf("path is required")
@@ -600,6 +1212,9 @@ func (a *App) ClearCompletedDownloads() {
 
 func (a *App) ClearAllDownloads() {
 	backend.ClearAllDownloads()
+	if a.downloads != nil {
+		a.downloads.SettleAll()
+	}
 }
 
 func (a *App) AddToDownloadQueue(isrc, trackName, artistName, albumName string) string {
@@ -616,6 +1231,96 @@ func (a *App) CancelAllQueuedItems() {
 	backend.CancelAllQueuedItems()
 }
 
+func (a *App) CancelDownloadItem(itemID string) bool {
+	// Cancel first so the manager sees the final status when it drops the job.
+	cancelled := backend.CancelDownloadItem(itemID)
+	if a.downloads != nil {
+		a.downloads.Remove(itemID)
+	}
+	return cancelled
+}
+
+func (a *App) PauseDownloadItem(itemID string) bool {
+	if !backend.PauseDownloadItem(itemID) {
+		return false
+	}
+	if a.downloads != nil {
+		a.downloads.Remove(itemID)
+	}
+	return true
+}
+
+func (a *App) ResumeDownloadItem(itemID string) error {
+	if a.downloads == nil {
+		return fmt.Errorf("download manager not initialized")
+	}
+
+	item, ok := backend.GetDownloadItem(itemID)
+	if !ok {
+		return fmt.Errorf("download item not found: %s", itemID)
+	}
+	if item.Status != backend.StatusPaused {
+		return fmt.Errorf("download item is not paused: %s", item.Status)
+	}
+	if item.Request == "" {
+		return fmt.Errorf("download item has no stored request: %s", itemID)
+	}
+
+	var req DownloadRequest
+	if err := json.Unmarshal([]byte(item.Request), &req); err != nil {
+		return fmt.Errorf("failed to decode stored request: %v", err)
+	}
+
+	if !backend.RequeueDownloadItem(itemID) {
+		return fmt.Errorf("download item cannot be resumed in state: %s", item.Status)
+	}
+
+	req.ItemID = itemID
+	return a.downloads.Submit(a.downloadJob(req))
+}
+
+func (a *App) RetryDownloadItem(itemID string) (DownloadResponse, error) {
+	item, ok := backend.GetDownloadItem(itemID)
+	if !ok {
+		return DownloadResponse{
+			Success: false,
+			Error:   "Download item not found",
+		}, fmt.Errorf("download item not found: %s", itemID)
+	}
+
+	if item.Request == "" {
+		return DownloadResponse{
+			Success: false,
+			Error:   "Download item has no stored request",
+			ItemID:  itemID,
+		}, fmt.Errorf("download item has no stored request: %s", itemID)
+	}
+
+	var req DownloadRequest
+	if err := json.Unmarshal([]byte(item.Request), &req); err != nil {
+		return DownloadResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Failed to decode stored request: %v", err),
+			ItemID:  itemID,
+		}, err
+	}
+
+	if !backend.RequeueDownloadItem(itemID) {
+		return DownloadResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Download item cannot be retried in state: %s", item.Status),
+			ItemID:  itemID,
+		}, fmt.Errorf("download item cannot be retried in state: %s", item.Status)
+	}
+
+	req.ItemID = itemID
+	resp, err := a.DownloadTrack(req)
+	if a.downloads != nil {
+		a.downloads.Settle(itemID)
+	}
+	return resp, err
+}
+
 // MPV Player methods for frontend integration
 
 // MPVLoadTrack loads a track URL into the MPV player
@@ -745,20 +1450,248 @@ func (a *App) AnalyzeTrack(filePath string) (string, error) {
 	return string(jsonData), nil
 }
 
+func (a *App) GetSpectrogram(filePath string, options backend.SpectrumOptions) (string, error) {
+	if filePath == "" {
+		return "", fmt.Errorf("file path is required")
+	}
+
+	result, err := backend.AnalyzeSpectrumWithOptions(filePath, options)
+	if err != nil {
+		return "", fmt.Errorf("failed to analyze spectrum: %v", err)
+	}
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode response: %v", err)
+	}
+
+	return string(jsonData), nil
+}
+
+// RenderSpectrogram returns the track's spectrogram as a PNG data URL.
+func (a *App) RenderSpectrogram(filePath string, options backend.SpectrogramImageOptions) (string, error) {
+	if filePath == "" {
+		return "", fmt.Errorf("file path is required")
+	}
+
+	img, err := backend.RenderSpectrogram(filePath, options)
+	if err != nil {
+		return "", fmt.Errorf("failed to render spectrogram: %v", err)
+	}
+
+	return img.DataURL(), nil
+}
+
+// ExportSpectrogram writes the track's spectrogram as a PNG to outPath, or
+// into outPath when it is a directory, and returns the file written.
+func (a *App) ExportSpectrogram(filePath string, outPath string) (string, error) {
+	if filePath == "" {
+		return "", fmt.Errorf("file path is required")
+	}
+
+	path, err := backend.ExportSpectrogram(filePath, outPath, backend.SpectrogramImageOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to export spectrogram: %v", err)
+	}
+
+	return path, nil
+}
+
+// AnalyzeMultipleTracks analyzes the files on a worker pool, reusing cached
+// results for unchanged files. Progress is emitted per file as
+// "analysis:progress"; starting a new batch cancels the one running.
 func (a *App) AnalyzeMultipleTracks(filePaths []string) (string, error) {
+	return a.AnalyzeTracksWithOptions(filePaths, backend.BatchAnalysisOptions{})
+}
+
+func (a *App) AnalyzeTracksWithOptions(filePaths []string, options backend.BatchAnalysisOptions) (string, error) {
 	if len(filePaths) == 0 {
 		return "", fmt.Errorf("at least one file path is required")
 	}
 
-	results := make([]*backend.AnalysisResult, 0, len(filePaths))
-
-	for _, filePath := range filePaths {
-		result, err := backend.AnalyzeTrack(filePath)
-		if err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.analysisMu.Lock()
+	if a.analysisCancel != nil {
+		a.analysisCancel()
+	}
+	a.analysisCancel = cancel
+	a.analysisRun++
+	run := a.analysisRun
+	a.analysisMu.Unlock()
+	defer func() {
+		a.analysisMu.Lock()
+		cancel()
+		if a.analysisRun == run {
+			a.analysisCancel = nil
+		}
+		a.analysisMu.Unlock()
+	}()
+
+	runtime.EventsEmit(a.ctx, "analysis:status", "started")
+	// Only freshly analyzed files can have new defects; cached results were
+	// recorded when they were first analyzed.
+	fresh := make(map[string]bool)
+	analyzed, err := backend.AnalyzeBatch(ctx, filePaths, options, func(progress backend.AnalysisProgress) {
+		if !progress.Cached && progress.Error == "" {
+			fresh[progress.FilePath] = true
+		}
+		runtime.EventsEmit(a.ctx, "analysis:progress", progress)
+	})
+	if err != nil {
+		runtime.EventsEmit(a.ctx, "analysis:status", "cancelled")
+		return "", fmt.Errorf("analysis cancelled")
+	}
+	runtime.EventsEmit(a.ctx, "analysis:status", "completed")
 
+	results := make([]*backend.AnalysisResult, 0, len(analyzed))
+	defects := make(map[string][]string)
+	for _, result := range analyzed {
+		if result == nil {
 			continue
 		}
 		results = append(results, result)
+
+		if result.Defects != nil && fresh[result.FilePath] {
+			defects[result.FilePath] = result.Defects.Issues
+		}
+	}
+	if err := backend.SetHistoryDefects(defects, "SpotiFLAC"); err != nil {
+		fmt.Printf("Failed to record defects: %v\n", err)
+	}
+
+	jsonData, err := json.Marshal(results)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode response: %v", err)
+	}
+
+	return string(jsonData), nil
+}
+
+// CancelAnalysis stops the running batch analysis.
+func (a *App) CancelAnalysis() {
+	a.analysisMu.Lock()
+	defer a.analysisMu.Unlock()
+	if a.analysisCancel != nil {
+		a.analysisCancel()
+	}
+}
+
+func (a *App) ClearAnalysisCache() error {
+	return backend.ClearAnalysisCache()
+}
+
+// FindDuplicates groups the audio files under dir that hold the same
+// recording, by acoustic fingerprint.
+func (a *App) FindDuplicates(dir string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("directory is required")
+	}
+
+	groups, err := backend.FindDuplicates(dir)
+	if err != nil {
+		return "", err
+	}
+
+	jsonData, err := json.Marshal(groups)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode response: %v", err)
+	}
+
+	return string(jsonData), nil
+}
+
+// CompareAudioFiles tells whether filePath holds the same recording as
+// referencePath, e.g. a fresh download and the copy already in the library.
+func (a *App) CompareAudioFiles(filePath string, referencePath string) (string, error) {
+	if filePath == "" || referencePath == "" {
+		return "", fmt.Errorf("both file paths are required")
+	}
+
+	comparison, err := backend.CompareAudioFiles(filePath, referencePath)
+	if err != nil {
+		return "", err
+	}
+
+	jsonData, err := json.Marshal(comparison)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode response: %v", err)
+	}
+
+	return string(jsonData), nil
+}
+
+// GetFingerprint returns the encoded fingerprint of filePath, to be kept as a
+// reference for CompareWithFingerprint.
+func (a *App) GetFingerprint(filePath string) (string, error) {
+	if filePath == "" {
+		return "", fmt.Errorf("file path is required")
+	}
+
+	fp, err := backend.FingerprintFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint file: %v", err)
+	}
+
+	return fp.Encode(), nil
+}
+
+func (a *App) CompareWithFingerprint(filePath string, fingerprint string) (string, error) {
+	if filePath == "" {
+		return "", fmt.Errorf("file path is required")
+	}
+
+	ref, err := backend.DecodeFingerprint(fingerprint)
+	if err != nil {
+		return "", err
+	}
+
+	comparison, err := backend.CompareWithFingerprint(filePath, ref)
+	if err != nil {
+		return "", err
+	}
+
+	jsonData, err := json.Marshal(comparison)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode response: %v", err)
+	}
+
+	return string(jsonData), nil
+}
+
+func (a *App) ClearFingerprintIndex() error {
+	return backend.ClearFingerprintIndex()
+}
+
+// ScanForTranscodes checks every FLAC file under dir for the spectral cutoff
+// a lossy source leaves behind.
+func (a *App) ScanForTranscodes(dir string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("directory is required")
+	}
+
+	results, err := backend.ScanForTranscodes(dir)
+	if err != nil {
+		return "", err
+	}
+
+	jsonData, err := json.Marshal(results)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode response: %v", err)
+	}
+
+	return string(jsonData), nil
+}
+
+// ApplyReplayGain measures the FLAC files in albumDir and writes track and
+// album ReplayGain tags.
+func (a *App) ApplyReplayGain(albumDir string) (string, error) {
+	if albumDir == "" {
+		return "", fmt.Errorf("directory is required")
+	}
+
+	results, err := backend.ApplyAlbumReplayGain(albumDir)
+	if err != nil {
+		return "", err
 	}
 
 	jsonData, err := json.Marshal(results)
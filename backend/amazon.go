@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -36,9 +37,7 @@ type AfkarXYZResponse struct {
 
 func NewAmazonDownloader() *AmazonDownloader {
 	return &AmazonDownloader{
-		client: &http.Client{
-			Timeout: 120 * time.Second,
-		},
+		client:  newAPIClient(120 * time.Second),
 		regions: []string{"us", "eu"},
 	}
 }
@@ -107,9 +106,9 @@ func (a *AmazonDownloader) GetAmazonURLFromSpotify(spotifyTrackID string) (strin
 	return amazonURL, nil
 }
 
-func (a *AmazonDownloader) DownloadFromAfkarXYZ(amazonURL, outputDir, quality string) (string, error) {
+func (a *AmazonDownloader) DownloadFromAfkarXYZ(ctx context.Context, amazonURL, outputDir, quality string) (string, error) {
 	apiURL := "https://amazon.afkarxyz.fun/convert?url=" + url.QueryEscape(amazonURL)
-	req, err := http.NewRequest("GET", apiURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return "", err
 	}
@@ -145,48 +144,29 @@ func (a *AmazonDownloader) DownloadFromAfkarXYZ(amazonURL, outputDir, quality st
 	fileName = reg.ReplaceAllString(fileName, "")
 	filePath := filepath.Join(outputDir, fileName)
 
-	out, err := os.Create(filePath)
-	if err != nil {
-		return "", err
-	}
-	defer out.Close()
-
-	dlReq, _ := http.NewRequest("GET", downloadURL, nil)
-
-	dlResp, err := a.client.Do(dlReq)
-	if err != nil {
-		return "", err
-	}
-	defer dlResp.Body.Close()
-
 	fmt.Printf("Downloading from AfkarXYZ: %s\n", fileName)
-	pw := NewProgressWriter(out)
-	_, err = io.Copy(pw, dlResp.Body)
-	if err != nil {
-		out.Close()
-		os.Remove(filePath)
+	if _, err := DownloadResumable(ctx, newDownloadClient(), downloadURL, filePath); err != nil {
 		return "", err
 	}
 
-	fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(pw.GetTotal())/(1024*1024))
 	return filePath, nil
 }
 
-func (a *AmazonDownloader) DownloadFromService(amazonURL, outputDir, quality string) (string, error) {
-	return a.DownloadFromAfkarXYZ(amazonURL, outputDir, quality)
+func (a *AmazonDownloader) DownloadFromService(ctx context.Context, amazonURL, outputDir, quality string) (string, error) {
+	return a.DownloadFromAfkarXYZ(ctx, amazonURL, outputDir, quality)
 }
 
-func (a *AmazonDownloader) DownloadByURL(amazonURL, outputDir, quality, filenameFormat, playlistName, playlistOwner string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate, spotifyCoverURL string, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, embedMaxQualityCover bool, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string) (string, error) {
+func (a *AmazonDownloader) DownloadByURL(ctx context.Context, amazonURL string, track TrackRequest, opts DownloadOptions) (string, error) {
 
-	if outputDir != "." {
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
+	if opts.OutputDir != "." {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
 			return "", fmt.Errorf("failed to create output directory: %w", err)
 		}
 	}
 
-	if spotifyTrackName != "" && spotifyArtistName != "" {
-		expectedFilename := BuildExpectedFilename(spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate, filenameFormat, playlistName, playlistOwner, includeTrackNumber, position, spotifyDiscNumber, false)
-		expectedPath := filepath.Join(outputDir, expectedFilename)
+	if track.TrackName != "" && track.ArtistName != "" {
+		expectedFilename := BuildExpectedFilename(track.TrackName, track.ArtistName, track.AlbumName, track.AlbumArtist, track.ReleaseDate, opts.FilenameFormat, opts.PlaylistName, opts.PlaylistOwner, opts.IncludeTrackNumber, opts.Position, track.DiscNumber, false)
+		expectedPath := filepath.Join(opts.OutputDir, expectedFilename)
 
 		if fileInfo, err := os.Stat(expectedPath); err == nil && fileInfo.Size() > 0 {
 			fmt.Printf("File already exists: %s (%.2f MB)\n", expectedPath, float64(fileInfo.Size())/(1024*1024))
@@ -196,40 +176,40 @@ func (a *AmazonDownloader) DownloadByURL(amazonURL, outputDir, quality, filename
 
 	fmt.Printf("Using Amazon URL: %s\n", amazonURL)
 
-	filePath, err := a.DownloadFromService(amazonURL, outputDir, quality)
+	filePath, err := a.DownloadFromService(ctx, amazonURL, opts.OutputDir, opts.Quality)
 	if err != nil {
 		return "", err
 	}
 
-	if spotifyTrackName != "" && spotifyArtistName != "" {
-		safeArtist := sanitizeFilename(spotifyArtistName)
-		safeTitle := sanitizeFilename(spotifyTrackName)
-		safeAlbum := sanitizeFilename(spotifyAlbumName)
-		safeAlbumArtist := sanitizeFilename(spotifyAlbumArtist)
+	if track.TrackName != "" && track.ArtistName != "" {
+		safeArtist := sanitizeFilename(track.ArtistName)
+		safeTitle := sanitizeFilename(track.TrackName)
+		safeAlbum := sanitizeFilename(track.AlbumName)
+		safeAlbumArtist := sanitizeFilename(track.AlbumArtist)
 
 		year := ""
-		if len(spotifyReleaseDate) >= 4 {
-			year = spotifyReleaseDate[:4]
+		if len(track.ReleaseDate) >= 4 {
+			year = track.ReleaseDate[:4]
 		}
 
 		var newFilename string
 
-		if strings.Contains(filenameFormat, "{") {
-			newFilename = filenameFormat
+		if strings.Contains(opts.FilenameFormat, "{") {
+			newFilename = opts.FilenameFormat
 			newFilename = strings.ReplaceAll(newFilename, "{title}", safeTitle)
 			newFilename = strings.ReplaceAll(newFilename, "{artist}", safeArtist)
 			newFilename = strings.ReplaceAll(newFilename, "{album}", safeAlbum)
 			newFilename = strings.ReplaceAll(newFilename, "{album_artist}", safeAlbumArtist)
 			newFilename = strings.ReplaceAll(newFilename, "{year}", year)
 
-			if spotifyDiscNumber > 0 {
-				newFilename = strings.ReplaceAll(newFilename, "{disc}", fmt.Sprintf("%d", spotifyDiscNumber))
+			if track.DiscNumber > 0 {
+				newFilename = strings.ReplaceAll(newFilename, "{disc}", fmt.Sprintf("%d", track.DiscNumber))
 			} else {
 				newFilename = strings.ReplaceAll(newFilename, "{disc}", "")
 			}
 
-			if position > 0 {
-				newFilename = strings.ReplaceAll(newFilename, "{track}", fmt.Sprintf("%02d", position))
+			if opts.Position > 0 {
+				newFilename = strings.ReplaceAll(newFilename, "{track}", fmt.Sprintf("%02d", opts.Position))
 			} else {
 
 				newFilename = regexp.MustCompile(`\{track\}\.\s*`).ReplaceAllString(newFilename, "")
@@ -238,7 +218,7 @@ func (a *AmazonDownloader) DownloadByURL(amazonURL, outputDir, quality, filename
 			}
 		} else {
 
-			switch filenameFormat {
+			switch opts.FilenameFormat {
 			case "artist-title":
 				newFilename = fmt.Sprintf("%s - %s", safeArtist, safeTitle)
 			case "title":
@@ -247,13 +227,13 @@ func (a *AmazonDownloader) DownloadByURL(amazonURL, outputDir, quality, filename
 				newFilename = fmt.Sprintf("%s - %s", safeTitle, safeArtist)
 			}
 
-			if includeTrackNumber && position > 0 {
-				newFilename = fmt.Sprintf("%02d. %s", position, newFilename)
+			if opts.IncludeTrackNumber && opts.Position > 0 {
+				newFilename = fmt.Sprintf("%02d. %s", opts.Position, newFilename)
 			}
 		}
 
 		newFilename = newFilename + ".flac"
-		newFilePath := filepath.Join(outputDir, newFilename)
+		newFilePath := filepath.Join(opts.OutputDir, newFilename)
 
 		if err := os.Rename(filePath, newFilePath); err != nil {
 			fmt.Printf("Warning: Failed to rename file: %v\n", err)
@@ -265,42 +245,7 @@ func (a *AmazonDownloader) DownloadByURL(amazonURL, outputDir, quality, filename
 
 	fmt.Println("Embedding Spotify metadata...")
 
-	coverPath := ""
-
-	if spotifyCoverURL != "" {
-		coverPath = filePath + ".cover.jpg"
-		coverClient := NewCoverClient()
-		if err := coverClient.DownloadCoverToPath(spotifyCoverURL, coverPath, embedMaxQualityCover); err != nil {
-			fmt.Printf("Warning: Failed to download Spotify cover: %v\n", err)
-			coverPath = ""
-		} else {
-			defer os.Remove(coverPath)
-			fmt.Println("Spotify cover downloaded")
-		}
-	}
-
-	trackNumberToEmbed := spotifyTrackNumber
-	if trackNumberToEmbed == 0 {
-		trackNumberToEmbed = 1
-	}
-
-	metadata := Metadata{
-		Title:       spotifyTrackName,
-		Artist:      spotifyArtistName,
-		Album:       spotifyAlbumName,
-		AlbumArtist: spotifyAlbumArtist,
-		Date:        spotifyReleaseDate,
-		TrackNumber: trackNumberToEmbed,
-		TotalTracks: spotifyTotalTracks,
-		DiscNumber:  spotifyDiscNumber,
-		TotalDiscs:  spotifyTotalDiscs,
-		URL:         spotifyURL,
-		Copyright:   spotifyCopyright,
-		Publisher:   spotifyPublisher,
-		Description: "https://github.com/afkarxyz/SpotiFLAC",
-	}
-
-	if err := EmbedMetadata(filePath, metadata, coverPath); err != nil {
+	if err := embedTrackMetadata(filePath, track, opts); err != nil {
 		fmt.Printf("Warning: Failed to embed metadata: %v\n", err)
 	} else {
 		fmt.Println("Metadata embedded successfully")
@@ -311,12 +256,49 @@ func (a *AmazonDownloader) DownloadByURL(amazonURL, outputDir, quality, filename
 	return filePath, nil
 }
 
-func (a *AmazonDownloader) DownloadBySpotifyID(spotifyTrackID, outputDir, quality, filenameFormat, playlistName, playlistOwner string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate, spotifyCoverURL string, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, embedMaxQualityCover bool, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string) (string, error) {
+func (a *AmazonDownloader) DownloadBySpotifyID(ctx context.Context, track TrackRequest, opts DownloadOptions) (string, error) {
 
-	amazonURL, err := a.GetAmazonURLFromSpotify(spotifyTrackID)
+	amazonURL, err := a.GetAmazonURLFromSpotify(track.SpotifyID)
 	if err != nil {
 		return "", err
 	}
 
-	return a.DownloadByURL(amazonURL, outputDir, quality, filenameFormat, playlistName, playlistOwner, includeTrackNumber, position, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate, spotifyCoverURL, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks, embedMaxQualityCover, spotifyTotalDiscs, spotifyCopyright, spotifyPublisher, spotifyURL)
+	return a.DownloadByURL(ctx, amazonURL, track, opts)
+}
+
+type amazonProvider struct{}
+
+func (amazonProvider) Name() string { return "amazon" }
+
+func (amazonProvider) Resolve(ctx context.Context, track TrackRequest) (*ResolvedTrack, error) {
+	amazonURL := track.serviceURLFor("amazon", "amazon.")
+	if amazonURL == "" {
+		if track.ServiceURLs != nil {
+			return nil, fmt.Errorf("track not available on Amazon Music")
+		}
+		if track.SpotifyID == "" {
+			return nil, fmt.Errorf("spotify ID is required for Amazon Music")
+		}
+		var err error
+		amazonURL, err = NewAmazonDownloader().GetAmazonURLFromSpotify(track.SpotifyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ResolvedTrack{Provider: "amazon", URL: amazonURL, ISRC: track.ISRC}, nil
+}
+
+// ProbeQuality reports ErrQualityUnknown: the conversion service only tells
+// us the real stream format after converting the track.
+func (amazonProvider) ProbeQuality(ctx context.Context, resolved *ResolvedTrack) (*QualityInfo, error) {
+	return nil, ErrQualityUnknown
+}
+
+func (amazonProvider) FetchStream(ctx context.Context, resolved *ResolvedTrack, quality string) (string, error) {
+	return "", fmt.Errorf("amazon: streaming not yet implemented")
+}
+
+func (amazonProvider) Download(ctx context.Context, resolved *ResolvedTrack, track TrackRequest, opts DownloadOptions) (string, error) {
+	return NewAmazonDownloader().DownloadByURL(ctx, resolved.URL, track, opts)
 }
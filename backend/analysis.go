@@ -1,30 +1,41 @@
 package backend
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"os"
 
 	"github.com/go-flac/go-flac"
-	mewflac "github.com/mewkiz/flac"
 )
 
 type AnalysisResult struct {
-	FilePath      string        `json:"file_path"`
-	FileSize      int64         `json:"file_size"`
-	SampleRate    uint32        `json:"sample_rate"`
-	Channels      uint8         `json:"channels"`
-	BitsPerSample uint8         `json:"bits_per_sample"`
-	TotalSamples  uint64        `json:"total_samples"`
-	Duration      float64       `json:"duration"`
-	BitDepth      string        `json:"bit_depth"`
-	DynamicRange  float64       `json:"dynamic_range"`
-	PeakAmplitude float64       `json:"peak_amplitude"`
-	RMSLevel      float64       `json:"rms_level"`
-	Spectrum      *SpectrumData `json:"spectrum,omitempty"`
+	FilePath      string            `json:"file_path"`
+	FileSize      int64             `json:"file_size"`
+	SampleRate    uint32            `json:"sample_rate"`
+	Channels      uint8             `json:"channels"`
+	BitsPerSample uint8             `json:"bits_per_sample"`
+	TotalSamples  uint64            `json:"total_samples"`
+	Duration      float64           `json:"duration"`
+	BitDepth      string            `json:"bit_depth"`
+	DynamicRange  float64           `json:"dynamic_range"`
+	PeakAmplitude float64           `json:"peak_amplitude"`
+	RMSLevel      float64           `json:"rms_level"`
+	Spectrum      *SpectrumData     `json:"spectrum,omitempty"`
+	Transcode     *TranscodeVerdict `json:"transcode,omitempty"`
+	Effective     *EffectiveFormat  `json:"effective,omitempty"`
+	Loudness      *LoudnessResult   `json:"loudness,omitempty"`
+	Defects       *DefectReport     `json:"defects,omitempty"`
 }
 
+// AnalyzeTrack decodes the file once, in any format openAudioSource
+// supports, and feeds every measurement from that single pass.
 func AnalyzeTrack(filepath string) (*AnalysisResult, error) {
+	return AnalyzeTrackContext(context.Background(), filepath)
+}
+
+// AnalyzeTrackContext is AnalyzeTrack, stopping when ctx is cancelled.
+func AnalyzeTrackContext(ctx context.Context, filepath string) (*AnalysisResult, error) {
 	if !fileExists(filepath) {
 		return nil, fmt.Errorf("file does not exist: %s", filepath)
 	}
@@ -34,125 +45,131 @@ func AnalyzeTrack(filepath string) (*AnalysisResult, error) {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	f, err := flac.ParseFile(filepath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse FLAC file: %w", err)
-	}
-
 	result := &AnalysisResult{
 		FilePath: filepath,
 		FileSize: fileInfo.Size(),
 	}
 
-	if len(f.Meta) > 0 {
-		streamInfo := f.Meta[0]
-		if streamInfo.Type == flac.StreamInfo {
-
-			data := streamInfo.Data
-			if len(data) >= 18 {
-
-				result.SampleRate = uint32(data[10])<<12 | uint32(data[11])<<4 | uint32(data[12])>>4
-
-				result.Channels = ((data[12] >> 1) & 0x07) + 1
-
-				result.BitsPerSample = ((data[12]&0x01)<<4 | data[13]>>4) + 1
-
-				result.TotalSamples = uint64(data[13]&0x0F)<<32 |
-					uint64(data[14])<<24 |
-					uint64(data[15])<<16 |
-					uint64(data[16])<<8 |
-					uint64(data[17])
-
-				if result.SampleRate > 0 {
-					result.Duration = float64(result.TotalSamples) / float64(result.SampleRate)
-				}
+	levels := &levelSink{}
+	spectrum, _ := newSpectrumSink(SpectrumOptions{})
+	loudness := &loudnessSink{}
+	bitDepth := &bitDepthSink{}
+	defects := &defectSink{}
 
-			}
+	format, err := analyzeFileContext(ctx, filepath, levels, spectrum, loudness, bitDepth, defects)
+	if err != nil {
+		if format.SampleRate == 0 || ctx.Err() != nil {
+			spectrum.finish()
+			return nil, err
 		}
+		fmt.Printf("Warning: analysis stopped early: %v\n", err)
 	}
 
-	spectrum, err := AnalyzeSpectrum(filepath)
-	if err != nil {
+	result.SampleRate = uint32(format.SampleRate)
+	result.Channels = uint8(format.Channels)
+	result.BitsPerSample = uint8(format.SourceBits)
+	result.TotalSamples = format.TotalSamples
+	if result.TotalSamples == 0 {
+		result.TotalSamples = spectrum.position
+	}
+	if result.SampleRate > 0 {
+		result.Duration = float64(result.TotalSamples) / float64(result.SampleRate)
+	}
+
+	levels.apply(result)
 
+	if data, err := spectrum.result(); err != nil {
 		fmt.Printf("Warning: failed to analyze spectrum: %v\n", err)
 	} else {
-		result.Spectrum = spectrum
+		result.Spectrum = data
+		result.Transcode = DetectLossySource(data)
+	}
 
-		calculateRealAudioMetrics(result, filepath)
+	if format.Lossless {
+		result.Effective = bitDepth.result(format, result.Spectrum)
 	}
 
+	result.Loudness = loudness.result()
+	result.Defects = defects.result()
+
 	result.BitDepth = fmt.Sprintf("%d-bit", result.BitsPerSample)
 
 	return result, nil
 }
 
-func calculateRealAudioMetrics(result *AnalysisResult, filepath string) {
+// DownloadScan is what is checked on every finished download: a lossy-source
+// verdict, the effective format of hi-res files and decode defects. Fields
+// are nil when the check did not apply or found nothing to report.
+type DownloadScan struct {
+	Transcode *TranscodeVerdict
+	Effective *EffectiveFormat
+	Defects   *DefectReport
+}
 
-	samples, err := decodeFLACForMetrics(filepath)
+// ScanDownload runs the post-download checks in a single decode of path.
+// Only a hi-res container can hide a CD-quality master, so the effective
+// format is measured for files above 16-bit/48 kHz only.
+func ScanDownload(path string) (*DownloadScan, error) {
+	spectrum, err := newSpectrumSink(SpectrumOptions{})
 	if err != nil {
-		return
-	}
-
-	var peak float64
-	var sumSquares float64
-
-	for _, sample := range samples {
-		absVal := sample
-		if absVal < 0 {
-			absVal = -absVal
-		}
-		if absVal > peak {
-			peak = absVal
-		}
-		sumSquares += sample * sample
+		return nil, err
 	}
+	bitDepth := &bitDepthSink{}
+	defects := &defectSink{}
 
-	peakDB := 20.0 * math.Log10(peak)
-	result.PeakAmplitude = peakDB
-
-	rms := math.Sqrt(sumSquares / float64(len(samples)))
-	rmsDB := 20.0 * math.Log10(rms)
-	result.RMSLevel = rmsDB
-
-	result.DynamicRange = peakDB - rmsDB
-}
-
-func decodeFLACForMetrics(filepath string) ([]float64, error) {
-	stream, err := mewflac.ParseFile(filepath)
+	format, err := analyzeFile(path, spectrum, bitDepth, defects)
 	if err != nil {
+		spectrum.finish()
 		return nil, err
 	}
-	defer stream.Close()
 
-	maxSamples := 10000000
-	samples := make([]float64, 0, maxSamples)
-
-	for {
-		frame, err := stream.ParseNext()
-		if err != nil {
-			break
-		}
+	scan := &DownloadScan{Defects: defects.result()}
+	data, err := spectrum.result()
+	if err != nil {
+		fmt.Printf("Warning: failed to analyze spectrum: %v\n", err)
+	} else {
+		scan.Transcode = DetectLossySource(data)
+	}
+	if format.Lossless && (format.SourceBits > 16 || format.SampleRate > 48000) {
+		scan.Effective = bitDepth.result(format, data)
+	}
+	return scan, nil
+}
 
-		var channelSamples []int32
-		if len(frame.Subframes) > 0 {
-			channelSamples = frame.Subframes[0].Samples
-		}
+// levelSink tracks sample peak and RMS over every channel.
+type levelSink struct {
+	peak       float64
+	sumSquares float64
+	count      uint64
+}
 
-		maxVal := float64(int64(1) << (stream.Info.BitsPerSample - 1))
-		for _, sample := range channelSamples {
-			if len(samples) >= maxSamples {
-				return samples, nil
+func (s *levelSink) Write(block [][]int32, format audioFormat) {
+	scale := format.scale()
+	for _, channel := range block {
+		for _, sample := range channel {
+			v := math.Abs(float64(sample) * scale)
+			if v > s.peak {
+				s.peak = v
 			}
-			normalized := float64(sample) / maxVal
-			samples = append(samples, normalized)
+			s.sumSquares += v * v
 		}
+		s.count += uint64(len(channel))
+	}
+}
 
-		if len(samples) >= maxSamples {
-			break
-		}
+func (s *levelSink) apply(result *AnalysisResult) {
+	if s.count == 0 {
+		return
 	}
 
-	return samples, nil
+	peakDB := 20.0 * math.Log10(s.peak)
+	result.PeakAmplitude = peakDB
+
+	rms := math.Sqrt(s.sumSquares / float64(s.count))
+	rmsDB := 20.0 * math.Log10(rms)
+	result.RMSLevel = rmsDB
+
+	result.DynamicRange = peakDB - rmsDB
 }
 
 func GetFileSize(filepath string) (int64, error) {
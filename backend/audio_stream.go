@@ -0,0 +1,361 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	mewflac "github.com/mewkiz/flac"
+)
+
+// ffmpegBlockFrames is how many sample frames are read from the ffmpeg pipe
+// at a time.
+const ffmpegBlockFrames = 4096
+
+// losslessCodecs are the ffprobe codec names whose decoded samples are the
+// original ones, so bit-level analysis means something.
+var losslessCodecs = map[string]bool{
+	"flac": true, "alac": true, "wavpack": true, "ape": true, "tta": true, "mlp": true, "truehd": true,
+}
+
+// audioFormat describes the PCM an audioSource produces. BitsPerSample is the
+// width of the int32 samples it yields, which for sources decoded through
+// ffmpeg is 32 whatever SourceBits is.
+type audioFormat struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	SourceBits    int
+	// TotalSamples is per channel and is an estimate, or 0, when the
+	// container does not record it exactly.
+	TotalSamples uint64
+	Lossless     bool
+}
+
+// scale converts a sample to the -1..1 range.
+func (f audioFormat) scale() float64 {
+	return 1 / float64(int64(1)<<(f.BitsPerSample-1))
+}
+
+// audioSource yields decoded PCM one block at a time, one slice per channel.
+// Next returns io.EOF at the end of the stream. The returned slices are only
+// valid until the next call.
+type audioSource interface {
+	Format() audioFormat
+	Next() ([][]int32, error)
+	Close() error
+}
+
+// audioSink accumulates whatever it measures from each block of a stream.
+type audioSink interface {
+	Write(block [][]int32, format audioFormat)
+}
+
+// openAudioSource decodes FLAC natively and everything else, or FLAC that
+// mewkiz/flac cannot read, through ffmpeg.
+func openAudioSource(path string) (audioSource, error) {
+	if !fileExists(path) {
+		return nil, fmt.Errorf("file does not exist: %s", path)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".flac") {
+		src, err := newFLACSource(path)
+		if err == nil {
+			return src, nil
+		}
+		fmt.Printf("Native FLAC decoding failed, falling back to ffmpeg: %v\n", err)
+	}
+	return newFFmpegSource(path)
+}
+
+// streamAudio feeds every block of src to every sink. Decoding stops at the
+// first error or when ctx is cancelled; sinks keep what they saw up to that
+// point.
+func streamAudio(ctx context.Context, src audioSource, sinks ...audioSink) error {
+	format := src.Format()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		block, err := src.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		for _, sink := range sinks {
+			sink.Write(block, format)
+		}
+	}
+}
+
+// analyzeFile opens path and streams it through sinks in one pass.
+func analyzeFile(path string, sinks ...audioSink) (audioFormat, error) {
+	return analyzeFileContext(context.Background(), path, sinks...)
+}
+
+func analyzeFileContext(ctx context.Context, path string, sinks ...audioSink) (audioFormat, error) {
+	src, err := openAudioSource(path)
+	if err != nil {
+		return audioFormat{}, err
+	}
+	defer src.Close()
+
+	if err := streamAudio(ctx, src, sinks...); err != nil {
+		return src.Format(), fmt.Errorf("failed to decode audio: %w", err)
+	}
+	return src.Format(), nil
+}
+
+type flacSource struct {
+	stream *mewflac.Stream
+	format audioFormat
+	block  [][]int32
+}
+
+func newFLACSource(path string) (*flacSource, error) {
+	stream, err := mewflac.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FLAC: %w", err)
+	}
+
+	info := stream.Info
+	if info.SampleRate == 0 || info.NChannels == 0 {
+		stream.Close()
+		return nil, fmt.Errorf("invalid stream info")
+	}
+
+	return &flacSource{
+		stream: stream,
+		format: audioFormat{
+			SampleRate:    int(info.SampleRate),
+			Channels:      int(info.NChannels),
+			BitsPerSample: int(info.BitsPerSample),
+			SourceBits:    int(info.BitsPerSample),
+			TotalSamples:  info.NSamples,
+			Lossless:      true,
+		},
+		block: make([][]int32, info.NChannels),
+	}, nil
+}
+
+func (s *flacSource) Format() audioFormat { return s.format }
+
+func (s *flacSource) Next() ([][]int32, error) {
+	frame, err := s.stream.ParseNext()
+	if err != nil {
+		return nil, err
+	}
+	for ch := range s.block {
+		s.block[ch] = frame.Subframes[ch].Samples[:frame.Subframes[ch].NSamples]
+	}
+	return s.block, nil
+}
+
+func (s *flacSource) Close() error {
+	return s.stream.Close()
+}
+
+// ffmpegSource decodes any format ffmpeg understands to 32-bit PCM on a
+// pipe. A decode that stops early is reported as an error at the end of the
+// stream rather than as a short but complete one.
+type ffmpegSource struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	waited bool
+	reader *bufio.Reader
+	format audioFormat
+	raw    []byte
+	buf    [][]int32
+	block  [][]int32
+}
+
+func newFFmpegSource(path string) (*ffmpegSource, error) {
+	format, err := probeAudioFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ffmpegPath, err := GetFFmpegPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateExecutable(ffmpegPath); err != nil {
+		return nil, fmt.Errorf("invalid ffmpeg executable: %w", err)
+	}
+
+	cmd := exec.Command(ffmpegPath,
+		"-v", "error",
+		"-i", path,
+		"-map", "0:a:0",
+		"-f", "s32le",
+		"-acodec", "pcm_s32le",
+		"-",
+	)
+	setHideWindow(cmd)
+
+	s := &ffmpegSource{
+		cmd:    cmd,
+		format: format,
+		raw:    make([]byte, ffmpegBlockFrames*format.Channels*4),
+		buf:    make([][]int32, format.Channels),
+		block:  make([][]int32, format.Channels),
+	}
+	cmd.Stderr = &s.stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	s.stdout = stdout
+	s.reader = bufio.NewReaderSize(stdout, 64*1024)
+
+	for ch := range s.buf {
+		s.buf[ch] = make([]int32, ffmpegBlockFrames)
+	}
+	return s, nil
+}
+
+func (s *ffmpegSource) Format() audioFormat { return s.format }
+
+func (s *ffmpegSource) Next() ([][]int32, error) {
+	n, err := io.ReadFull(s.reader, s.raw)
+	frameBytes := s.format.Channels * 4
+	frames := n / frameBytes
+	if frames == 0 {
+		if err == nil || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			if waitErr := s.wait(); waitErr != nil {
+				return nil, waitErr
+			}
+			err = io.EOF
+		}
+		return nil, err
+	}
+
+	for i := 0; i < frames; i++ {
+		for ch := range s.buf {
+			offset := i*frameBytes + ch*4
+			s.buf[ch][i] = int32(binary.LittleEndian.Uint32(s.raw[offset:]))
+		}
+	}
+	for ch := range s.block {
+		s.block[ch] = s.buf[ch][:frames]
+	}
+	return s.block, nil
+}
+
+// wait reaps ffmpeg once its output is drained and turns a failed exit into
+// an error carrying what it printed.
+func (s *ffmpegSource) wait() error {
+	if s.waited {
+		return nil
+	}
+	s.waited = true
+
+	if err := s.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(s.stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg decode failed: %w: %s", err, msg)
+		}
+		return fmt.Errorf("ffmpeg decode failed: %w", err)
+	}
+	return nil
+}
+
+// Close stops ffmpeg if the caller did not read to the end.
+func (s *ffmpegSource) Close() error {
+	s.stdout.Close()
+	if !s.waited {
+		s.cmd.Process.Kill()
+		s.waited = true
+		s.cmd.Wait()
+	}
+	return nil
+}
+
+// probeAudioFormat reads the first audio stream's format with ffprobe.
+func probeAudioFormat(path string) (audioFormat, error) {
+	ffprobePath, err := GetFFprobePath()
+	if err != nil {
+		return audioFormat{}, err
+	}
+	if err := ValidateExecutable(ffprobePath); err != nil {
+		return audioFormat{}, fmt.Errorf("invalid ffprobe executable: %w", err)
+	}
+
+	cmd := exec.Command(ffprobePath,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-select_streams", "a:0",
+		"-show_streams",
+		"-show_format",
+		path,
+	)
+	setHideWindow(cmd)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return audioFormat{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecName        string `json:"codec_name"`
+			SampleRate       string `json:"sample_rate"`
+			Channels         int    `json:"channels"`
+			BitsPerSample    int    `json:"bits_per_sample"`
+			BitsPerRawSample string `json:"bits_per_raw_sample"`
+			Duration         string `json:"duration"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return audioFormat{}, err
+	}
+	if len(result.Streams) == 0 {
+		return audioFormat{}, fmt.Errorf("no audio stream found")
+	}
+
+	stream := result.Streams[0]
+	sampleRate, _ := strconv.Atoi(stream.SampleRate)
+	if sampleRate == 0 || stream.Channels == 0 {
+		return audioFormat{}, fmt.Errorf("invalid audio stream")
+	}
+
+	format := audioFormat{
+		SampleRate:    sampleRate,
+		Channels:      stream.Channels,
+		BitsPerSample: 32,
+		SourceBits:    stream.BitsPerSample,
+		Lossless:      losslessCodecs[stream.CodecName] || strings.HasPrefix(stream.CodecName, "pcm_"),
+	}
+	if raw, err := strconv.Atoi(stream.BitsPerRawSample); err == nil && raw > 0 {
+		format.SourceBits = raw
+	}
+
+	duration := stream.Duration
+	if duration == "" {
+		duration = result.Format.Duration
+	}
+	if seconds, err := strconv.ParseFloat(duration, 64); err == nil && seconds > 0 {
+		format.TotalSamples = uint64(math.Round(seconds * float64(sampleRate)))
+	}
+
+	return format, nil
+}
@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+)
+
+const (
+	analysisCacheBucket = "AnalysisCache"
+	// analysisCacheVersion is bumped whenever AnalysisResult changes in a
+	// way that makes older cached entries wrong.
+	analysisCacheVersion = 1
+)
+
+// AnalysisProgress is reported once per file as a batch runs.
+type AnalysisProgress struct {
+	FilePath  string `json:"file_path"`
+	Index     int    `json:"index"`
+	Completed int    `json:"completed"`
+	Total     int    `json:"total"`
+	Cached    bool   `json:"cached,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// BatchAnalysisOptions controls AnalyzeBatch. Workers defaults to half the
+// CPUs, since every track already spreads its FFTs over all of them. With
+// Refresh set, cached results are ignored and replaced.
+type BatchAnalysisOptions struct {
+	Workers int  `json:"workers,omitempty"`
+	Refresh bool `json:"refresh,omitempty"`
+}
+
+// AnalyzeBatch analyzes paths on a pool of workers and returns the results in
+// the same order, with nil for files that failed or were not reached before
+// ctx was cancelled. Results are cached in history.db by path, size and
+// modification time, so unchanged files are not decoded again.
+//
+// Batch results leave out Spectrum, which is too large to cache or send for a
+// whole library; use AnalyzeSpectrum or RenderSpectrogram for one track.
+func AnalyzeBatch(ctx context.Context, paths []string, options BatchAnalysisOptions, progress func(AnalysisProgress)) ([]*AnalysisResult, error) {
+	workers := options.Workers
+	if workers <= 0 {
+		workers = max(1, runtime.NumCPU()/2)
+	}
+
+	results := make([]*AnalysisResult, len(paths))
+	indexes := make(chan int)
+
+	var mu sync.Mutex
+	completed := 0
+	report := func(p AnalysisProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		completed++
+		p.Completed = completed
+		p.Total = len(paths)
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(paths)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				result, cached, err := analyzeCached(ctx, paths[i], options.Refresh)
+				if ctx.Err() != nil {
+					return
+				}
+
+				p := AnalysisProgress{FilePath: paths[i], Index: i, Cached: cached}
+				if err != nil {
+					p.Error = err.Error()
+				} else {
+					results[i] = result
+				}
+				report(p)
+			}
+		}()
+	}
+
+feed:
+	for i := range paths {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results, ctx.Err()
+}
+
+func analyzeCached(ctx context.Context, path string, refresh bool) (*AnalysisResult, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("file does not exist: %s", path)
+	}
+
+	if !refresh {
+		var result AnalysisResult
+		if loadFileCache(analysisCacheBucket, path, analysisCacheVersion, info, &result) {
+			return &result, true, nil
+		}
+	}
+
+	result, err := AnalyzeTrackContext(ctx, path)
+	if err != nil {
+		return nil, false, err
+	}
+	result.Spectrum = nil
+
+	if err := saveFileCache(analysisCacheBucket, path, analysisCacheVersion, info, result); err != nil {
+		fmt.Printf("Failed to cache analysis of %s: %v\n", path, err)
+	}
+	return result, false, nil
+}
+
+// ClearAnalysisCache drops every cached analysis result.
+func ClearAnalysisCache() error {
+	return clearFileCache(analysisCacheBucket)
+}
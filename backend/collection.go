@@ -0,0 +1,216 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Collection types.
+const (
+	CollectionAlbum    = "album"
+	CollectionPlaylist = "playlist"
+	CollectionTrack    = "track"
+)
+
+// Collection is an album, playlist or single track flattened into the
+// per-track metadata a download needs.
+type Collection struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name"`
+	Owner  string          `json:"owner,omitempty"`
+	Tracks []TrackMetadata `json:"tracks"`
+}
+
+// FetchCollection fetches spotifyURL and returns its tracks. Artist URLs are
+// rejected, since a discography is many collections rather than one.
+func (c *SpotifyMetadataClient) FetchCollection(ctx context.Context, spotifyURL string) (*Collection, error) {
+	data, err := c.GetFilteredData(ctx, spotifyURL, false, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch payload := data.(type) {
+	case TrackResponse:
+		return &Collection{
+			Type:   CollectionTrack,
+			Name:   payload.Track.Name,
+			Tracks: []TrackMetadata{payload.Track},
+		}, nil
+	case *AlbumResponsePayload:
+		return &Collection{
+			Type:   CollectionAlbum,
+			Name:   payload.AlbumInfo.Name,
+			Tracks: collectionTracks(payload.TrackList),
+		}, nil
+	case PlaylistResponsePayload:
+		return playlistCollection(payload), nil
+	case *ArtistDiscographyPayload:
+		return nil, fmt.Errorf("artist URLs are not supported, download their albums instead")
+	default:
+		return nil, fmt.Errorf("unsupported Spotify data: %T", data)
+	}
+}
+
+func playlistCollection(payload PlaylistResponsePayload) *Collection {
+	// formatPlaylistData keeps the playlist's own name in Owner.Name.
+	return &Collection{
+		Type:   CollectionPlaylist,
+		Name:   payload.PlaylistInfo.Owner.Name,
+		Owner:  payload.PlaylistInfo.Owner.DisplayName,
+		Tracks: collectionTracks(payload.TrackList),
+	}
+}
+
+func collectionTracks(list []AlbumTrackMetadata) []TrackMetadata {
+	tracks := make([]TrackMetadata, 0, len(list))
+	for _, t := range list {
+		tracks = append(tracks, TrackMetadata{
+			SpotifyID:   t.SpotifyID,
+			Artists:     t.Artists,
+			Name:        t.Name,
+			AlbumName:   t.AlbumName,
+			AlbumArtist: t.AlbumArtist,
+			DurationMS:  t.DurationMS,
+			Images:      t.Images,
+			ReleaseDate: t.ReleaseDate,
+			TrackNumber: t.TrackNumber,
+			TotalTracks: t.TotalTracks,
+			DiscNumber:  t.DiscNumber,
+			TotalDiscs:  t.TotalDiscs,
+			ExternalURL: t.ExternalURL,
+			ISRC:        t.ISRC,
+			Plays:       t.Plays,
+			PreviewURL:  t.PreviewURL,
+			IsExplicit:  t.IsExplicit,
+		})
+	}
+	return tracks
+}
+
+// CollectionJob groups the queue items queued for one collection.
+type CollectionJob struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Type      string   `json:"type"`
+	Name      string   `json:"name"`
+	Owner     string   `json:"owner,omitempty"`
+	ItemIDs   []string `json:"item_ids"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+// CollectionProgress counts a collection's items by queue status. Removed
+// items were cleared from the queue before finishing. Progress is the
+// finished fraction, from 0 to 1.
+type CollectionProgress struct {
+	ID          string  `json:"id"`
+	Type        string  `json:"type"`
+	Name        string  `json:"name"`
+	Total       int     `json:"total"`
+	Queued      int     `json:"queued"`
+	Downloading int     `json:"downloading"`
+	Paused      int     `json:"paused"`
+	Completed   int     `json:"completed"`
+	Skipped     int     `json:"skipped"`
+	Failed      int     `json:"failed"`
+	Interrupted int     `json:"interrupted"`
+	Removed     int     `json:"removed"`
+	Progress    float64 `json:"progress"`
+	Done        bool    `json:"done"`
+}
+
+var (
+	collectionJobs     = make(map[string]*CollectionJob)
+	collectionJobsLock sync.RWMutex
+)
+
+// NewCollectionJob registers the queue items of a collection and returns the
+// job. Jobs live for the session only; their items are persisted with the
+// rest of the queue.
+func NewCollectionJob(url string, collection *Collection, itemIDs []string) *CollectionJob {
+	now := time.Now()
+	job := &CollectionJob{
+		ID:        fmt.Sprintf("collection-%d", now.UnixNano()),
+		URL:       url,
+		Type:      collection.Type,
+		Name:      collection.Name,
+		Owner:     collection.Owner,
+		ItemIDs:   itemIDs,
+		CreatedAt: now.Unix(),
+	}
+
+	collectionJobsLock.Lock()
+	collectionJobs[job.ID] = job
+	collectionJobsLock.Unlock()
+	return job
+}
+
+func GetCollectionJobs() []CollectionJob {
+	collectionJobsLock.RLock()
+	defer collectionJobsLock.RUnlock()
+
+	jobs := make([]CollectionJob, 0, len(collectionJobs))
+	for _, job := range collectionJobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+func GetCollectionProgress(id string) (CollectionProgress, bool) {
+	collectionJobsLock.RLock()
+	job, ok := collectionJobs[id]
+	collectionJobsLock.RUnlock()
+	if !ok {
+		return CollectionProgress{}, false
+	}
+
+	p := CollectionProgress{
+		ID:    job.ID,
+		Type:  job.Type,
+		Name:  job.Name,
+		Total: len(job.ItemIDs),
+	}
+	for _, itemID := range job.ItemIDs {
+		item, ok := GetDownloadItem(itemID)
+		if !ok {
+			p.Removed++
+			continue
+		}
+		switch item.Status {
+		case StatusQueued:
+			p.Queued++
+		case StatusDownloading:
+			p.Downloading++
+		case StatusPaused:
+			p.Paused++
+		case StatusCompleted:
+			p.Completed++
+		case StatusSkipped:
+			p.Skipped++
+		case StatusFailed:
+			p.Failed++
+		case StatusInterrupted:
+			p.Interrupted++
+		}
+	}
+
+	finished := p.Completed + p.Skipped + p.Failed + p.Removed
+	if p.Total > 0 {
+		p.Progress = float64(finished) / float64(p.Total)
+	}
+	p.Done = finished == p.Total
+	return p, true
+}
+
+// ForgetCollectionJob drops a collection job. Its queue items are left alone.
+func ForgetCollectionJob(id string) bool {
+	collectionJobsLock.Lock()
+	defer collectionJobsLock.Unlock()
+
+	if _, ok := collectionJobs[id]; !ok {
+		return false
+	}
+	delete(collectionJobs, id)
+	return true
+}
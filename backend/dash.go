@@ -0,0 +1,185 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	dashSegmentWorkers = 6
+	dashSegmentWindow  = 24
+	dashSegmentRetries = 4
+)
+
+type dashSegment struct {
+	index int
+	data  []byte
+	err   error
+}
+
+func fetchDASHSegment(ctx context.Context, client *http.Client, segmentURL string) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt < dashSegmentRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(500<<uint(attempt-1)) * time.Millisecond
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", segmentURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("status %d", resp.StatusCode)
+			if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+				return nil, lastErr
+			}
+			continue
+		}
+
+		body := newIdleTimeoutReader(resp.Body, downloadIdleTimeout)
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("failed after %d attempts: %w", dashSegmentRetries, lastErr)
+}
+
+// downloadDASHSegments fetches the init segment and every media segment of a
+// DASH stream and writes them to out in order. Media segments are fetched by
+// a small worker pool, each with its own retries, while at most
+// dashSegmentWindow segments are held in memory waiting for their turn.
+func downloadDASHSegments(ctx context.Context, client *http.Client, initURL string, mediaURLs []string, out io.Writer) (int64, error) {
+	fmt.Print("Downloading init segment... ")
+	initData, err := fetchDASHSegment(ctx, client, initURL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download init segment: %w", err)
+	}
+	if _, err := out.Write(initData); err != nil {
+		return 0, fmt.Errorf("failed to write init segment: %w", err)
+	}
+	fmt.Println("OK")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	totalSegments := len(mediaURLs)
+	jobs := make(chan int)
+	results := make(chan dashSegment, dashSegmentWindow)
+	window := make(chan struct{}, dashSegmentWindow)
+
+	go func() {
+		defer close(jobs)
+		for i := range mediaURLs {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < dashSegmentWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				data, err := fetchDASHSegment(ctx, client, mediaURLs[i])
+				select {
+				case results <- dashSegment{index: i, data: data, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	itemID := DownloadItemIDFromContext(ctx)
+	totalBytes := int64(len(initData))
+	lastTime := time.Now()
+	lastBytes := totalBytes
+	var speedMBps float64
+
+	pending := make(map[int][]byte)
+	next := 0
+	for next < totalSegments {
+		result, ok := <-results
+		if !ok {
+			if err := context.Cause(ctx); err != nil {
+				return totalBytes, err
+			}
+			return totalBytes, fmt.Errorf("segment download stopped at %d/%d", next, totalSegments)
+		}
+		if result.err != nil {
+			cancel()
+			return totalBytes, fmt.Errorf("failed to download segment %d: %w", result.index+1, result.err)
+		}
+		pending[result.index] = result.data
+
+		for data, ready := pending[next]; ready; data, ready = pending[next] {
+			if _, err := out.Write(data); err != nil {
+				cancel()
+				return totalBytes, fmt.Errorf("failed to write segment %d: %w", next+1, err)
+			}
+			delete(pending, next)
+			totalBytes += int64(len(data))
+			next++
+			<-window
+		}
+
+		mbDownloaded := float64(totalBytes) / (1024 * 1024)
+		now := time.Now()
+		if timeDiff := now.Sub(lastTime).Seconds(); timeDiff > 0.5 {
+			speedMBps = (float64(totalBytes-lastBytes) / (1024 * 1024)) / timeDiff
+			lastTime = now
+			lastBytes = totalBytes
+		}
+		if itemID == "" {
+			SetDownloadSpeed(speedMBps)
+			SetDownloadProgress(mbDownloaded)
+		} else if next > 0 {
+			estimatedTotal := mbDownloaded * float64(totalSegments+1) / float64(next+1)
+			UpdateItemProgressWithTotal(itemID, mbDownloaded, speedMBps, estimatedTotal)
+		}
+
+		fmt.Printf("\rDownloading: %.2f MB (%d/%d segments)", mbDownloaded, next, totalSegments)
+	}
+
+	return totalBytes, nil
+}
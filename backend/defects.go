@@ -0,0 +1,246 @@
+package backend
+
+import (
+	"fmt"
+	"math"
+)
+
+// Defect scan thresholds.
+const (
+	// defectClipRunSamples is how many consecutive samples at full scale
+	// count as a clipped run rather than a peak that happens to touch it.
+	defectClipRunSamples  = 3
+	defectClipRuns        = 10
+	defectDCOffset        = 0.002 // about -54 dBFS
+	defectSilenceDB       = -60.0
+	defectSilenceSeconds  = 5.0
+	defectDropoutSeconds  = 0.01
+	defectMonoCorrelation = 0.999
+	defectMaxRegions      = 100
+)
+
+// Defect names, as stored in HistoryItem.Defects.
+const (
+	DefectClipping        = "clipping"
+	DefectDCOffset        = "dc_offset"
+	DefectLeadingSilence  = "leading_silence"
+	DefectTrailingSilence = "trailing_silence"
+	DefectDropouts        = "dropouts"
+	DefectFakeStereo      = "fake_stereo"
+)
+
+// DefectRegion is a stretch of a track, in seconds.
+type DefectRegion struct {
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+}
+
+// DefectReport lists problems found in the decoded PCM. DCOffset is per
+// channel on the -1..1 scale; ChannelCorrelation is only set for stereo.
+// Dropouts holds at most the first 100 regions; DropoutCount counts all of
+// them.
+type DefectReport struct {
+	ClippedSamples     uint64         `json:"clipped_samples"`
+	ClippedRuns        int            `json:"clipped_runs"`
+	LongestClipRun     int            `json:"longest_clip_run"`
+	DCOffset           []float64      `json:"dc_offset"`
+	LeadingSilence     float64        `json:"leading_silence"`
+	TrailingSilence    float64        `json:"trailing_silence"`
+	Dropouts           []DefectRegion `json:"dropouts,omitempty"`
+	DropoutCount       int            `json:"dropout_count"`
+	ChannelCorrelation float64        `json:"channel_correlation,omitempty"`
+	FakeStereo         bool           `json:"fake_stereo,omitempty"`
+	Issues             []string       `json:"issues,omitempty"`
+}
+
+// ScanDefects decodes path and reports clipping, DC offset, silence,
+// dropouts and mono-as-stereo.
+func ScanDefects(path string) (*DefectReport, error) {
+	sink := &defectSink{}
+	if _, err := analyzeFile(path, sink); err != nil {
+		return nil, err
+	}
+	if report := sink.result(); report != nil {
+		return report, nil
+	}
+	return nil, fmt.Errorf("no audio samples found")
+}
+
+// defectSink scans the stream frame by frame, keeping only running sums and
+// the current run lengths.
+type defectSink struct {
+	started    bool
+	sampleRate int
+	scale      float64
+	clipLevel  float64
+	silence    float64
+	minDropout uint64
+
+	frames   uint64
+	clipRun  []int
+	sums     []float64
+	report   DefectReport
+	heard    bool
+	lastLoud uint64
+	zeroRun  uint64
+
+	sumLR, sumLL, sumRR float64
+}
+
+func (s *defectSink) start(format audioFormat) {
+	s.started = true
+	s.sampleRate = format.SampleRate
+	s.scale = format.scale()
+
+	// A sample within one LSB of full scale at the source's bit depth is
+	// clipped, whatever width the decoder widened it to.
+	refBits := format.SourceBits
+	if refBits <= 0 {
+		refBits = 16
+	}
+	s.clipLevel = 1 - math.Ldexp(1, -(refBits-1))
+	s.silence = math.Pow(10, defectSilenceDB/20)
+	s.minDropout = uint64(defectDropoutSeconds * float64(format.SampleRate))
+
+	s.clipRun = make([]int, format.Channels)
+	s.sums = make([]float64, format.Channels)
+}
+
+func (s *defectSink) Write(block [][]int32, format audioFormat) {
+	if !s.started {
+		s.start(format)
+	}
+
+	stereo := len(block) == 2
+	for i := range block[0] {
+		loud, zero := false, true
+
+		for ch, channel := range block {
+			x := float64(channel[i]) * s.scale
+			s.sums[ch] += x
+
+			a := math.Abs(x)
+			if a > s.silence {
+				loud = true
+			}
+			if channel[i] != 0 {
+				zero = false
+			}
+
+			if a >= s.clipLevel {
+				s.clipRun[ch]++
+				s.report.ClippedSamples++
+			} else {
+				s.endClipRun(ch)
+			}
+		}
+
+		if stereo {
+			l := float64(block[0][i]) * s.scale
+			r := float64(block[1][i]) * s.scale
+			s.sumLR += l * r
+			s.sumLL += l * l
+			s.sumRR += r * r
+		}
+
+		if zero {
+			s.zeroRun++
+		} else {
+			s.endZeroRun()
+		}
+
+		if loud {
+			if !s.heard {
+				s.heard = true
+				s.report.LeadingSilence = s.seconds(s.frames)
+			}
+			s.lastLoud = s.frames
+		}
+		s.frames++
+	}
+}
+
+func (s *defectSink) endClipRun(ch int) {
+	run := s.clipRun[ch]
+	if run >= defectClipRunSamples {
+		s.report.ClippedRuns++
+	}
+	if run > s.report.LongestClipRun {
+		s.report.LongestClipRun = run
+	}
+	s.clipRun[ch] = 0
+}
+
+// endZeroRun records a run of digital silence that started after the music
+// did as a dropout. Runs at the start and end of the track are left to the
+// silence measurements.
+func (s *defectSink) endZeroRun() {
+	run := s.zeroRun
+	s.zeroRun = 0
+	if !s.heard || run < s.minDropout {
+		return
+	}
+
+	s.report.DropoutCount++
+	if len(s.report.Dropouts) < defectMaxRegions {
+		s.report.Dropouts = append(s.report.Dropouts, DefectRegion{
+			Start:    s.seconds(s.frames - run),
+			Duration: s.seconds(run),
+		})
+	}
+}
+
+func (s *defectSink) seconds(frames uint64) float64 {
+	return float64(frames) / float64(s.sampleRate)
+}
+
+// result is nil when no audio was seen.
+func (s *defectSink) result() *DefectReport {
+	if !s.started || s.frames == 0 {
+		return nil
+	}
+
+	for ch := range s.clipRun {
+		s.endClipRun(ch)
+	}
+
+	r := s.report
+	r.DCOffset = make([]float64, len(s.sums))
+	for ch, sum := range s.sums {
+		r.DCOffset[ch] = sum / float64(s.frames)
+	}
+
+	if s.heard {
+		r.TrailingSilence = s.seconds(s.frames - s.lastLoud - 1)
+	} else {
+		r.LeadingSilence = s.seconds(s.frames)
+	}
+
+	if len(s.sums) == 2 && s.sumLL > 0 && s.sumRR > 0 {
+		r.ChannelCorrelation = s.sumLR / math.Sqrt(s.sumLL*s.sumRR)
+		r.FakeStereo = r.ChannelCorrelation >= defectMonoCorrelation
+	}
+
+	if r.ClippedRuns >= defectClipRuns {
+		r.Issues = append(r.Issues, DefectClipping)
+	}
+	for _, dc := range r.DCOffset {
+		if math.Abs(dc) >= defectDCOffset {
+			r.Issues = append(r.Issues, DefectDCOffset)
+			break
+		}
+	}
+	if r.LeadingSilence >= defectSilenceSeconds {
+		r.Issues = append(r.Issues, DefectLeadingSilence)
+	}
+	if r.TrailingSilence >= defectSilenceSeconds {
+		r.Issues = append(r.Issues, DefectTrailingSilence)
+	}
+	if r.DropoutCount > 0 {
+		r.Issues = append(r.Issues, DefectDropouts)
+	}
+	if r.FakeStereo {
+		r.Issues = append(r.Issues, DefectFakeStereo)
+	}
+	return &r
+}
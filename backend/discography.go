@@ -0,0 +1,376 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Edition policies pick the release kept from a group of editions.
+const (
+	EditionMostTracks     = "most_tracks"
+	EditionOriginal       = "original"
+	EditionLatestRemaster = "latest_remaster"
+)
+
+// DiscographyOptions controls PlanDiscography. Types limits the release types
+// considered (album, single, compilation; all when empty).
+type DiscographyOptions struct {
+	Policy string   `json:"policy"`
+	Types  []string `json:"types,omitempty"`
+}
+
+// PlannedRelease is a release the plan downloads. Editions lists the other
+// editions it was chosen over. A single keeps only the tracks not already on
+// an album or an earlier single; DroppedTracks counts the others.
+type PlannedRelease struct {
+	Release       DiscographyAlbumMetadata `json:"release"`
+	Tracks        []TrackMetadata          `json:"tracks"`
+	Editions      []string                 `json:"editions,omitempty"`
+	DroppedTracks int                      `json:"dropped_tracks,omitempty"`
+}
+
+// SkippedRelease is a release the plan leaves out, and why: "edition" when
+// another edition (KeptID) was chosen, "covered" for a single whose tracks
+// are all on kept releases and "unavailable" when its tracks could not be
+// fetched.
+type SkippedRelease struct {
+	Release DiscographyAlbumMetadata `json:"release"`
+	Reason  string                   `json:"reason"`
+	KeptID  string                   `json:"kept_id,omitempty"`
+}
+
+// DiscographyPlan is an artist's discography reduced to one edition per
+// release, in release order.
+type DiscographyPlan struct {
+	ArtistID string           `json:"artist_id"`
+	Artist   string           `json:"artist"`
+	Policy   string           `json:"policy"`
+	Releases []PlannedRelease `json:"releases"`
+	Skipped  []SkippedRelease `json:"skipped"`
+}
+
+// TrackCount is the number of tracks the plan downloads.
+func (p *DiscographyPlan) TrackCount() int {
+	n := 0
+	for _, r := range p.Releases {
+		n += len(r.Tracks)
+	}
+	return n
+}
+
+type discographyRelease struct {
+	meta   DiscographyAlbumMetadata
+	title  string
+	tracks []TrackMetadata
+	keys   *trackSet
+}
+
+// PlanDiscography fetches an artist's discography with every release's
+// tracks and decides what to download. Releases of the same type are editions
+// of one another when they share a track and either their normalized titles
+// match or at least half of the smaller one's tracks appear on the other; one
+// edition per group is kept according to the policy. Singles are then cut
+// down to the tracks not already kept on an album or an earlier single.
+func PlanDiscography(ctx context.Context, input string, options DiscographyOptions) (*DiscographyPlan, error) {
+	id, err := parseArtistID(input)
+	if err != nil {
+		return nil, err
+	}
+
+	switch options.Policy {
+	case "":
+		options.Policy = EditionMostTracks
+	case EditionMostTracks, EditionOriginal, EditionLatestRemaster:
+	default:
+		return nil, fmt.Errorf("unknown edition policy: %s", options.Policy)
+	}
+
+	client := NewSpotifyMetadataClient()
+	raw, err := client.fetchArtistDiscography(ctx, spotifyURI{Type: "artist_discography", ID: id, DiscographyGroup: "all"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discography: %w", err)
+	}
+	payload, err := client.formatArtistDiscographyData(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discography: %w", err)
+	}
+
+	plan := &DiscographyPlan{
+		ArtistID: id,
+		Artist:   payload.ArtistInfo.Name,
+		Policy:   options.Policy,
+		Releases: []PlannedRelease{},
+		Skipped:  []SkippedRelease{},
+	}
+
+	tracksByAlbum := make(map[string][]AlbumTrackMetadata)
+	for _, t := range payload.TrackList {
+		tracksByAlbum[t.AlbumID] = append(tracksByAlbum[t.AlbumID], t)
+	}
+
+	byType := make(map[string][]*discographyRelease)
+	for _, meta := range payload.AlbumList {
+		meta.AlbumType = strings.ToLower(meta.AlbumType)
+		if !wantsReleaseType(options.Types, meta.AlbumType) {
+			continue
+		}
+		tracks := collectionTracks(tracksByAlbum[meta.ID])
+		if len(tracks) == 0 {
+			plan.Skipped = append(plan.Skipped, SkippedRelease{Release: meta, Reason: "unavailable"})
+			continue
+		}
+		r := &discographyRelease{
+			meta:   meta,
+			title:  normalizeReleaseTitle(meta.Name),
+			tracks: tracks,
+			keys:   newTrackSet(),
+		}
+		for _, t := range tracks {
+			r.keys.add(t)
+		}
+		byType[meta.AlbumType] = append(byType[meta.AlbumType], r)
+	}
+
+	var kept []*discographyRelease
+	editions := make(map[string][]string)
+	for _, releases := range byType {
+		for _, group := range groupEditions(releases) {
+			best := pickEdition(group, options.Policy)
+			kept = append(kept, best)
+			for _, r := range group {
+				if r == best {
+					continue
+				}
+				editions[best.meta.ID] = append(editions[best.meta.ID], r.meta.ID)
+				plan.Skipped = append(plan.Skipped, SkippedRelease{Release: r.meta, Reason: "edition", KeptID: best.meta.ID})
+			}
+		}
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		// Singles go last so they are checked against every kept album.
+		si, sj := kept[i].meta.AlbumType == "single", kept[j].meta.AlbumType == "single"
+		if si != sj {
+			return sj
+		}
+		return kept[i].meta.ReleaseDate < kept[j].meta.ReleaseDate
+	})
+
+	covered := newTrackSet()
+	for _, r := range kept {
+		planned := PlannedRelease{Release: r.meta, Editions: editions[r.meta.ID]}
+		for _, t := range r.tracks {
+			if r.meta.AlbumType == "single" && covered.has(t) {
+				planned.DroppedTracks++
+				continue
+			}
+			planned.Tracks = append(planned.Tracks, t)
+		}
+		for _, t := range r.tracks {
+			covered.add(t)
+		}
+
+		if len(planned.Tracks) == 0 {
+			plan.Skipped = append(plan.Skipped, SkippedRelease{Release: r.meta, Reason: "covered"})
+			continue
+		}
+		plan.Releases = append(plan.Releases, planned)
+	}
+
+	sort.SliceStable(plan.Releases, func(i, j int) bool {
+		return plan.Releases[i].Release.ReleaseDate < plan.Releases[j].Release.ReleaseDate
+	})
+	return plan, nil
+}
+
+func wantsReleaseType(types []string, releaseType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if strings.EqualFold(t, releaseType) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupEditions splits releases into groups of editions of the same release.
+func groupEditions(releases []*discographyRelease) [][]*discographyRelease {
+	parent := make([]int, len(releases))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range releases {
+		for j := i + 1; j < len(releases); j++ {
+			if sameRelease(releases[i], releases[j]) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	index := make(map[int]int)
+	var groups [][]*discographyRelease
+	for i, r := range releases {
+		root := find(i)
+		g, ok := index[root]
+		if !ok {
+			g = len(groups)
+			index[root] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], r)
+	}
+	return groups
+}
+
+// sameRelease reports whether two releases are editions of each other. A
+// matching title alone is not enough, since an artist can have two
+// self-titled albums or two "Greatest Hits"; the releases must share at least
+// one track as well.
+func sameRelease(a, b *discographyRelease) bool {
+	small, large := a, b
+	if len(b.tracks) < len(a.tracks) {
+		small, large = b, a
+	}
+	shared := 0
+	for _, t := range small.tracks {
+		if large.keys.has(t) {
+			shared++
+		}
+	}
+	if shared == 0 {
+		return false
+	}
+	return (a.title != "" && a.title == b.title) || shared*2 >= len(small.tracks)
+}
+
+// pickEdition chooses the edition a policy keeps. Ties fall back to the
+// earlier release, then the one with more tracks.
+func pickEdition(group []*discographyRelease, policy string) *discographyRelease {
+	best := group[0]
+	for _, r := range group[1:] {
+		if betterEdition(r, best, policy) {
+			best = r
+		}
+	}
+	return best
+}
+
+func betterEdition(a, b *discographyRelease, policy string) bool {
+	switch policy {
+	case EditionOriginal:
+		if a.meta.ReleaseDate != b.meta.ReleaseDate {
+			return a.meta.ReleaseDate < b.meta.ReleaseDate
+		}
+	case EditionLatestRemaster:
+		ra, rb := isRemaster(a.meta.Name), isRemaster(b.meta.Name)
+		if ra != rb {
+			return ra
+		}
+		if a.meta.ReleaseDate != b.meta.ReleaseDate {
+			return a.meta.ReleaseDate > b.meta.ReleaseDate
+		}
+	default:
+		if len(a.tracks) != len(b.tracks) {
+			return len(a.tracks) > len(b.tracks)
+		}
+	}
+
+	if a.meta.ReleaseDate != b.meta.ReleaseDate {
+		return a.meta.ReleaseDate < b.meta.ReleaseDate
+	}
+	return len(a.tracks) > len(b.tracks)
+}
+
+var (
+	editionWords     = regexp.MustCompile(`(?i)\b(deluxe|remaster(ed)?|expanded|edition|anniversary|bonus|special|collector'?s|reissue|mono|stereo|explicit|clean)\b`)
+	editionSuffix    = regexp.MustCompile(`\s+-\s+[^-]*$`)
+	editionBrackets  = regexp.MustCompile(`\s*[(\[][^)\]]*[)\]]`)
+	nonAlphanumerics = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	remasterWord     = regexp.MustCompile(`(?i)\bremaster(ed)?\b`)
+)
+
+// normalizeReleaseTitle reduces a release or track title to what editions
+// share: bracketed or dash-separated parts naming an edition ("Deluxe",
+// "2011 Remaster") are dropped, then case and punctuation. Other qualifiers
+// such as "(Live)" are kept, since they are different recordings.
+func normalizeReleaseTitle(title string) string {
+	title = editionBrackets.ReplaceAllStringFunc(title, func(part string) string {
+		if editionWords.MatchString(part) {
+			return ""
+		}
+		return part
+	})
+	if suffix := editionSuffix.FindString(title); suffix != "" && editionWords.MatchString(suffix) {
+		title = strings.TrimSuffix(title, suffix)
+	}
+	title = nonAlphanumerics.ReplaceAllString(strings.ToLower(title), " ")
+	return strings.TrimSpace(title)
+}
+
+func isRemaster(title string) bool {
+	return remasterWord.MatchString(title)
+}
+
+// sameRecordingToleranceMS is how far the durations of two tracks with the
+// same title and artists may be apart for them to count as one recording.
+const sameRecordingToleranceMS = 2000
+
+// trackSet identifies recordings across releases: by the ISRC field, which
+// is the Spotify track ID for Spotify metadata, and by normalized title and
+// artists, since reissues often carry the same recording under a new ID. A
+// title match also needs durations within sameRecordingToleranceMS, so
+// generic titles such as "Intro" do not tie unrelated tracks together.
+type trackSet struct {
+	ids map[string]bool
+	// titles holds the durations seen for each title and artists key.
+	titles map[string][]int
+}
+
+func newTrackSet() *trackSet {
+	return &trackSet{ids: make(map[string]bool), titles: make(map[string][]int)}
+}
+
+func trackTitleKey(t TrackMetadata) string {
+	title := normalizeReleaseTitle(t.Name)
+	if title == "" {
+		return ""
+	}
+	return title + "|" + strings.ToLower(t.Artists)
+}
+
+func (s *trackSet) add(t TrackMetadata) {
+	if t.ISRC != "" {
+		s.ids[t.ISRC] = true
+	}
+	if key := trackTitleKey(t); key != "" && t.DurationMS > 0 {
+		s.titles[key] = append(s.titles[key], t.DurationMS)
+	}
+}
+
+func (s *trackSet) has(t TrackMetadata) bool {
+	if t.ISRC != "" && s.ids[t.ISRC] {
+		return true
+	}
+	if t.DurationMS <= 0 {
+		return false
+	}
+	for _, d := range s.titles[trackTitleKey(t)] {
+		if max(d, t.DurationMS)-min(d, t.DurationMS) <= sameRecordingToleranceMS {
+			return true
+		}
+	}
+	return false
+}
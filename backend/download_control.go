@@ -0,0 +1,141 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDownloadCancelled = errors.New("download cancelled")
+	ErrDownloadPaused    = errors.New("download paused")
+)
+
+type downloadItemIDKey struct{}
+
+type activeDownload struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+var (
+	activeDownloadCancels     = make(map[string]activeDownload)
+	activeDownloadCancelsLock sync.Mutex
+)
+
+// BeginDownloadItem returns a context for an in-flight download that can be
+// cancelled or paused through CancelDownloadItem and PauseDownloadItem. The
+// returned func must be called once the download returns.
+func BeginDownloadItem(id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	ctx = context.WithValue(ctx, downloadItemIDKey{}, id)
+
+	activeDownloadCancelsLock.Lock()
+	activeDownloadCancels[id] = activeDownload{ctx: ctx, cancel: cancel}
+	activeDownloadCancelsLock.Unlock()
+
+	return ctx, func() {
+		activeDownloadCancelsLock.Lock()
+		delete(activeDownloadCancels, id)
+		activeDownloadCancelsLock.Unlock()
+		cancel(nil)
+	}
+}
+
+func DownloadItemIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(downloadItemIDKey{}).(string)
+	return id
+}
+
+// stopActiveDownload cancels an in-flight download. It reports false when
+// there is none, or when it was already stopped and has not returned yet.
+func stopActiveDownload(id string, cause error) bool {
+	activeDownloadCancelsLock.Lock()
+	active, ok := activeDownloadCancels[id]
+	activeDownloadCancelsLock.Unlock()
+
+	if !ok || active.ctx.Err() != nil {
+		return false
+	}
+	active.cancel(cause)
+	return true
+}
+
+// CancelDownloadItem stops an in-flight download or cancels a waiting one.
+// It reports false when the item is missing, already cancelled or finished.
+func CancelDownloadItem(id string) bool {
+	if stopActiveDownload(id, ErrDownloadCancelled) {
+		return true
+	}
+
+	queuePersistLock.Lock()
+	defer queuePersistLock.Unlock()
+
+	cancelled := false
+	item, ok := updateQueueItem(id, func(item *DownloadItem) {
+		switch item.Status {
+		case StatusQueued, StatusPaused, StatusInterrupted:
+			item.Status = StatusSkipped
+			item.EndTime = time.Now().Unix()
+			item.ErrorMessage = "Cancelled"
+			item.Speed = 0
+			cancelled = true
+		}
+	})
+	if !ok || !cancelled {
+		return false
+	}
+	persistQueueItems(item)
+	return true
+}
+
+// PauseDownloadItem stops an in-flight download or holds a queued one until
+// it is resumed.
+func PauseDownloadItem(id string) bool {
+	if stopActiveDownload(id, ErrDownloadPaused) {
+		return true
+	}
+
+	queuePersistLock.Lock()
+	defer queuePersistLock.Unlock()
+
+	paused := false
+	item, ok := updateQueueItem(id, func(item *DownloadItem) {
+		if item.Status == StatusQueued {
+			item.Status = StatusPaused
+			paused = true
+		}
+	})
+	if !ok || !paused {
+		return false
+	}
+	persistQueueItems(item)
+	return true
+}
+
+// FinishStoppedDownloadItem records the outcome of a download that returned
+// early because its context was cancelled. It reports whether the item was
+// stopped by CancelDownloadItem or PauseDownloadItem.
+func FinishStoppedDownloadItem(ctx context.Context, id string) bool {
+	switch context.Cause(ctx) {
+	case ErrDownloadCancelled:
+		changeQueueItem(id, func(item *DownloadItem) {
+			item.Status = StatusSkipped
+			item.EndTime = time.Now().Unix()
+			item.ErrorMessage = "Cancelled"
+			item.Speed = 0
+		})
+		return true
+	case ErrDownloadPaused:
+		changeQueueItem(id, func(item *DownloadItem) {
+			item.Status = StatusPaused
+			item.Speed = 0
+		})
+		return true
+	}
+	return false
+}
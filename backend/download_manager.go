@@ -0,0 +1,360 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DownloadJob is a single queued download scheduled by the DownloadManager.
+// Run performs the download and is responsible for the item's queue
+// transitions; the manager only fails items that Run left unfinished and
+// skips jobs whose item is no longer queued when a worker picks them up.
+// Done, if set, is called once the item reaches a final outcome: it ran, or
+// was removed before starting. A job that leaves the manager because its item
+// was paused holds on to Done, and the next job submitted for the same item
+// without a Done of its own inherits it.
+type DownloadJob struct {
+	ItemID  string
+	Service string
+	Run     func() error
+	Done    func()
+}
+
+type DownloadManagerConfig struct {
+	Workers        int            `json:"workers"`
+	ProviderLimits map[string]int `json:"provider_limits"`
+}
+
+func DefaultDownloadManagerConfig() DownloadManagerConfig {
+	return DownloadManagerConfig{
+		Workers: 3,
+		ProviderLimits: map[string]int{
+			"tidal":  2,
+			"qobuz":  2,
+			"amazon": 1,
+		},
+	}
+}
+
+// providerSlots enforces the per-provider concurrency caps. A slot is held
+// for each provider attempt rather than for a whole job, so auto-mode jobs
+// count against whichever provider they are currently trying. The caps apply
+// to every download, whether or not it came through a DownloadManager.
+var providerSlots = newProviderLimiter()
+
+type providerLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limits map[string]int
+	active map[string]int
+	// onRelease is called without the lock held whenever a slot frees up.
+	onRelease func()
+}
+
+func newProviderLimiter() *providerLimiter {
+	l := &providerLimiter{
+		limits: make(map[string]int),
+		active: make(map[string]int),
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *providerLimiter) setLimits(limits map[string]int) {
+	l.mu.Lock()
+	l.limits = limits
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
+func (l *providerLimiter) hasCapacity(provider string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.free(provider)
+}
+
+func (l *providerLimiter) free(provider string) bool {
+	limit, ok := l.limits[provider]
+	if !ok || limit <= 0 {
+		return true
+	}
+	return l.active[provider] < limit
+}
+
+// acquire waits for a slot on provider and returns the func that gives it
+// back. It fails with the context's cause if ctx ends first.
+func (l *providerLimiter) acquire(ctx context.Context, provider string) (func(), error) {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	})
+	defer stop()
+
+	l.mu.Lock()
+	for !l.free(provider) {
+		if ctx.Err() != nil {
+			l.mu.Unlock()
+			return nil, context.Cause(ctx)
+		}
+		l.cond.Wait()
+	}
+	l.active[provider]++
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.active[provider]--
+			if l.active[provider] <= 0 {
+				delete(l.active, provider)
+			}
+			l.cond.Broadcast()
+			onRelease := l.onRelease
+			l.mu.Unlock()
+			if onRelease != nil {
+				onRelease()
+			}
+		})
+	}, nil
+}
+
+// DownloadManager runs queued download jobs on a pool of workers. Jobs are
+// started in submission order, except that a job for a specific provider that
+// is already at its concurrency cap is passed over until a slot frees up.
+type DownloadManager struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	config  DownloadManagerConfig
+	pending []*DownloadJob
+	// held keeps the Done hooks of paused items until they are resubmitted
+	// or settle.
+	held    map[string]func()
+	spawned int
+	stopped bool
+}
+
+func NewDownloadManager(config DownloadManagerConfig) *DownloadManager {
+	m := &DownloadManager{
+		held: make(map[string]func()),
+	}
+	m.cond = sync.NewCond(&m.mu)
+
+	providerSlots.mu.Lock()
+	providerSlots.onRelease = func() {
+		m.mu.Lock()
+		m.cond.Broadcast()
+		m.mu.Unlock()
+	}
+	providerSlots.mu.Unlock()
+
+	m.SetConfig(config)
+	return m
+}
+
+func (m *DownloadManager) SetConfig(config DownloadManagerConfig) {
+	if config.Workers <= 0 {
+		config.Workers = DefaultDownloadManagerConfig().Workers
+	}
+
+	limits := make(map[string]int, len(config.ProviderLimits))
+	for provider, limit := range config.ProviderLimits {
+		limits[strings.ToLower(strings.TrimSpace(provider))] = limit
+	}
+	config.ProviderLimits = limits
+	providerSlots.setLimits(limits)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.config = config
+	for m.spawned < config.Workers && !m.stopped {
+		go m.worker()
+		m.spawned++
+	}
+	m.cond.Broadcast()
+}
+
+func (m *DownloadManager) Config() DownloadManagerConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	limits := make(map[string]int, len(m.config.ProviderLimits))
+	for provider, limit := range m.config.ProviderLimits {
+		limits[provider] = limit
+	}
+	return DownloadManagerConfig{
+		Workers:        m.config.Workers,
+		ProviderLimits: limits,
+	}
+}
+
+func (m *DownloadManager) Submit(jobs ...*DownloadJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stopped {
+		return fmt.Errorf("download manager is stopped")
+	}
+
+	for _, job := range jobs {
+		if job == nil || job.Run == nil {
+			continue
+		}
+		job.Service = strings.ToLower(strings.TrimSpace(job.Service))
+		if done, ok := m.held[job.ItemID]; ok && job.Done == nil {
+			job.Done = done
+			delete(m.held, job.ItemID)
+		}
+		m.pending = append(m.pending, job)
+	}
+	m.cond.Broadcast()
+	return nil
+}
+
+// Remove drops a job that has not started yet. It reports whether a pending
+// job with that item ID was found. The job's Done is held if the item is
+// paused and called otherwise; Remove also settles an item held earlier.
+func (m *DownloadManager) Remove(itemID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, job := range m.pending {
+		if job.ItemID == itemID {
+			m.pending = append(m.pending[:i], m.pending[i+1:]...)
+			m.leave(job)
+			return true
+		}
+	}
+	m.settle(itemID)
+	return false
+}
+
+// Settle calls the held Done of an item that is no longer paused, for items
+// that were resumed or cancelled outside the manager.
+func (m *DownloadManager) Settle(itemID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settle(itemID)
+}
+
+// SettleAll calls the held Done of every item that is no longer paused, such
+// as paused items cleared from the queue.
+func (m *DownloadManager) SettleAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for itemID := range m.held {
+		m.settle(itemID)
+	}
+}
+
+func (m *DownloadManager) settle(itemID string) {
+	done, ok := m.held[itemID]
+	if !ok || itemPaused(itemID) {
+		return
+	}
+	delete(m.held, itemID)
+	go done()
+}
+
+// leave hands a job's Done to held or runs it, depending on whether its item
+// is paused. The caller holds m.mu.
+func (m *DownloadManager) leave(job *DownloadJob) {
+	if job.Done == nil {
+		return
+	}
+	if itemPaused(job.ItemID) {
+		m.held[job.ItemID] = job.Done
+		return
+	}
+	go job.Done()
+}
+
+func itemPaused(itemID string) bool {
+	item, ok := GetDownloadItem(itemID)
+	return ok && item.Status == StatusPaused
+}
+
+func (m *DownloadManager) PendingCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.pending)
+}
+
+func (m *DownloadManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = true
+	m.pending = nil
+	m.cond.Broadcast()
+}
+
+func (m *DownloadManager) nextJob() (*DownloadJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		if m.stopped || m.spawned > m.config.Workers {
+			m.spawned--
+			return nil, false
+		}
+
+		// The slot itself is taken per attempt in downloadFromProvider; this
+		// only keeps workers from picking jobs that would wait for one.
+		for i, job := range m.pending {
+			if providerSlots.hasCapacity(job.Service) {
+				m.pending = append(m.pending[:i], m.pending[i+1:]...)
+				return job, true
+			}
+		}
+
+		m.cond.Wait()
+	}
+}
+
+func (m *DownloadManager) worker() {
+	for {
+		job, ok := m.nextJob()
+		if !ok {
+			return
+		}
+
+		m.runJob(job)
+
+		m.mu.Lock()
+		m.leave(job)
+		m.mu.Unlock()
+	}
+}
+
+func (m *DownloadManager) runJob(job *DownloadJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			FailDownloadItem(job.ItemID, fmt.Sprintf("Download failed: %v", r))
+		}
+	}()
+
+	if item, ok := GetDownloadItem(job.ItemID); ok && item.Status != StatusQueued {
+		return
+	}
+
+	err := job.Run()
+
+	item, ok := GetDownloadItem(job.ItemID)
+	if !ok {
+		return
+	}
+	if item.Status == StatusQueued || item.Status == StatusDownloading {
+		if err == nil {
+			err = fmt.Errorf("download finished without a result")
+		}
+		FailDownloadItem(job.ItemID, fmt.Sprintf("Download failed: %v", err))
+	}
+}
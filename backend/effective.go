@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	// paddedBitsRatio is the share of non-zero samples whose low bits must
+	// all be zero before those bits count as padding.
+	paddedBitsRatio = 0.999
+	// upsampledEmptyDB is how close to the noise floor the band above an
+	// original Nyquist has to stay for the file to count as upsampled.
+	upsampledEmptyDB = 10.0
+)
+
+// originalSampleRates are the rates hi-res files are commonly upsampled from.
+var originalSampleRates = []int{44100, 48000, 88200, 96000}
+
+// EffectiveFormat is the bit depth and sample rate a file actually carries,
+// which can be lower than its STREAMINFO when a 16-bit/44.1 kHz master was
+// zero-padded and resampled into a "hi-res" container.
+type EffectiveFormat struct {
+	BitDepth    int    `json:"bit_depth"`
+	SampleRate  int    `json:"sample_rate"`
+	Padded      bool   `json:"padded"`
+	Upsampled   bool   `json:"upsampled"`
+	Description string `json:"description"`
+}
+
+// IsFake reports whether the file is padded or upsampled.
+func (e *EffectiveFormat) IsFake() bool {
+	return e != nil && (e.Padded || e.Upsampled)
+}
+
+// DetectEffectiveFormat decodes path to find how many of its bits are in use
+// and looks at spectrum for an empty band above a lower original Nyquist. A
+// nil spectrum skips the upsampling check. Lossy sources have no meaningful
+// bit depth and are rejected.
+func DetectEffectiveFormat(path string, spectrum *SpectrumData) (*EffectiveFormat, error) {
+	sink := &bitDepthSink{}
+	format, err := analyzeFile(path, sink)
+	if err != nil {
+		return nil, err
+	}
+	if !format.Lossless {
+		return nil, fmt.Errorf("effective bit depth needs a lossless source")
+	}
+	return sink.result(format, spectrum), nil
+}
+
+// bitDepthSink counts, for every non-zero sample, how many low-order bits are
+// zero.
+type bitDepthSink struct {
+	// zeroBits[k] counts the non-zero samples with exactly k trailing zero
+	// bits.
+	zeroBits [33]uint64
+	nonZero  uint64
+}
+
+func (s *bitDepthSink) Write(block [][]int32, format audioFormat) {
+	if !format.Lossless {
+		return
+	}
+	for _, channel := range block {
+		for _, sample := range channel {
+			if sample == 0 {
+				continue
+			}
+			s.zeroBits[bits.TrailingZeros32(uint32(sample))]++
+			s.nonZero++
+		}
+	}
+}
+
+// result compares what the samples use with the source's nominal format.
+// Samples decoded through ffmpeg are 32-bit, so the padding found in them is
+// measured against format.BitsPerSample and compared with SourceBits.
+func (s *bitDepthSink) result(format audioFormat, spectrum *SpectrumData) *EffectiveFormat {
+	result := &EffectiveFormat{
+		BitDepth:   effectiveBitDepth(s.zeroBits[:], s.nonZero, format.BitsPerSample),
+		SampleRate: effectiveSampleRate(spectrum, format.SampleRate),
+	}
+	if format.SourceBits > 0 && result.BitDepth > format.SourceBits {
+		result.BitDepth = format.SourceBits
+	}
+	result.Padded = format.SourceBits > 0 && result.BitDepth < format.SourceBits
+	result.Upsampled = result.SampleRate < format.SampleRate
+	result.Description = fmt.Sprintf("effective %d-bit/%.1fkHz", result.BitDepth, float64(result.SampleRate)/1000)
+
+	return result
+}
+
+// effectiveBitDepth drops the low bits that are zero in practically every
+// non-zero sample. Digital silence says nothing either way.
+func effectiveBitDepth(zeroBits []uint64, nonZero uint64, bps int) int {
+	if nonZero == 0 {
+		return bps
+	}
+
+	var atLeast uint64
+	padded := 0
+	for k := len(zeroBits) - 1; k >= 1; k-- {
+		atLeast += zeroBits[k]
+		if k < bps && float64(atLeast) >= paddedBitsRatio*float64(nonZero) {
+			padded = k
+			break
+		}
+	}
+	return bps - padded
+}
+
+// effectiveSampleRate returns the lowest common sample rate whose Nyquist the
+// content never crosses, or sampleRate when the band above every candidate
+// carries audio.
+func effectiveSampleRate(spectrum *SpectrumData, sampleRate int) int {
+	if spectrum == nil || !spectrum.isLinear() || len(spectrum.TimeSlices) == 0 || spectrum.FreqBins == 0 {
+		return sampleRate
+	}
+
+	binHz := spectrum.MaxFreq / float64(spectrum.FreqBins)
+	level := smoothLevels(averageSpectrum(spectrum), int(transcodeSmoothingHz/binHz))
+	floor := percentile(level, 0.05)
+
+	for _, rate := range originalSampleRates {
+		nyquist := float64(rate) / 2
+		if nyquist >= 0.95*spectrum.MaxFreq {
+			break
+		}
+		above := bandMax(level, nyquist*1.05, spectrum.MaxFreq*0.95, binHz)
+		below := bandMean(level, nyquist*0.5, nyquist*0.9, binHz)
+		if above < floor+upsampledEmptyDB && below >= floor+transcodeContentDB {
+			return rate
+		}
+	}
+	return sampleRate
+}
+
+func bandMax(levels []float64, fromHz, toHz, binHz float64) float64 {
+	from, to := max(0, int(fromHz/binHz)), min(len(levels), int(toHz/binHz)+1)
+	peak := math.Inf(-1)
+	if from >= to {
+		return peak
+	}
+	for _, v := range levels[from:to] {
+		peak = math.Max(peak, v)
+	}
+	return peak
+}
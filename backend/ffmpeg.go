@@ -230,11 +230,12 @@ func downloadAndExtract(url, destDir string, progressCallback func(int), progres
 	defer os.Remove(tmpFile.Name())
 	defer tmpFile.Close()
 
-	resp, err := http.Get(url)
+	resp, err := newDownloadClient().Get(url)
 	if err != nil {
 		return fmt.Errorf("failed to download: %w", err)
 	}
-	defer resp.Body.Close()
+	body := newIdleTimeoutReader(resp.Body, downloadIdleTimeout)
+	defer body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("failed to download: HTTP %d", resp.StatusCode)
@@ -254,7 +255,7 @@ func downloadAndExtract(url, destDir string, progressCallback func(int), progres
 
 	buf := make([]byte, 32*1024)
 	for {
-		n, err := resp.Body.Read(buf)
+		n, err := body.Read(buf)
 		if n > 0 {
 			_, writeErr := tmpFile.Write(buf[:n])
 			if writeErr != nil {
@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// fftPlan holds the bit-reversal permutation and twiddle factors for one
+// radix-2 FFT size, so transforms of that size allocate nothing.
+type fftPlan struct {
+	n        int
+	reversed []int
+	twiddles []complex128
+}
+
+var fftPlans sync.Map
+
+// getFFTPlan returns the cached plan for n, which must be a power of two.
+func getFFTPlan(n int) *fftPlan {
+	if plan, ok := fftPlans.Load(n); ok {
+		return plan.(*fftPlan)
+	}
+
+	plan := &fftPlan{
+		n:        n,
+		reversed: make([]int, n),
+		twiddles: make([]complex128, n/2),
+	}
+	shift := bits.UintSize - bits.Len(uint(n-1))
+	for i := range plan.reversed {
+		plan.reversed[i] = int(bits.Reverse(uint(i)) >> shift)
+	}
+	for k := range plan.twiddles {
+		angle := -2 * math.Pi * float64(k) / float64(n)
+		plan.twiddles[k] = complex(math.Cos(angle), math.Sin(angle))
+	}
+
+	actual, _ := fftPlans.LoadOrStore(n, plan)
+	return actual.(*fftPlan)
+}
+
+// transform runs an in-place iterative FFT on x, which must have length n.
+func (p *fftPlan) transform(x []complex128) {
+	for i, j := range p.reversed {
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= p.n; size <<= 1 {
+		half := size / 2
+		step := p.n / size
+		for start := 0; start < p.n; start += size {
+			for k := 0; k < half; k++ {
+				t := p.twiddles[k*step] * x[start+k+half]
+				x[start+k+half] = x[start+k] - t
+				x[start+k] += t
+			}
+		}
+	}
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"encoding/json"
+	"os"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// fileCacheEntry wraps a value computed from a file's contents. It is only
+// valid while the file keeps the same size and modification time, and while
+// the caller's version matches.
+type fileCacheEntry struct {
+	Version int             `json:"version"`
+	Size    int64           `json:"size"`
+	ModTime int64           `json:"mod_time"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// loadFileCache reads the entry for path from bucket into v and reports
+// whether it was found and still matches info.
+func loadFileCache(bucket, path string, version int, info os.FileInfo, v any) bool {
+	if historyDB == nil {
+		return false
+	}
+
+	var entry fileCacheEntry
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		if data := b.Get([]byte(path)); data != nil {
+			return json.Unmarshal(data, &entry)
+		}
+		return nil
+	})
+	if err != nil || entry.Value == nil {
+		return false
+	}
+	if entry.Version != version || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+		return false
+	}
+	return json.Unmarshal(entry.Value, v) == nil
+}
+
+func saveFileCache(bucket, path string, version int, info os.FileInfo, v any) error {
+	if historyDB == nil {
+		return nil
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(fileCacheEntry{
+		Version: version,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Value:   value,
+	})
+	if err != nil {
+		return err
+	}
+
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(path), buf)
+	})
+}
+
+func clearFileCache(bucket string) error {
+	if historyDB == nil {
+		return nil
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucket)) == nil {
+			return nil
+		}
+		return tx.DeleteBucket([]byte(bucket))
+	})
+}
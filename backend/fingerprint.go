@@ -0,0 +1,460 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	fingerprintBucket  = "Fingerprints"
+	fingerprintVersion = 1
+
+	// Frames are about 0.37 s long and start every 0.125 s, whatever the
+	// sample rate, so fingerprints of different encodes line up.
+	fingerprintFrameSeconds = 0.37
+	fingerprintHopSeconds   = 0.125
+	fingerprintMaxSeconds   = 180
+	fingerprintMinHz        = 28.0
+	fingerprintMaxHz        = 3520.0
+	fingerprintBits         = 24
+
+	// Fingerprints are compared at every offset up to 10 s either way, over
+	// at least 10 s of overlap.
+	fingerprintMaxOffset  = 80
+	fingerprintMinOverlap = 80
+
+	// FingerprintMatchThreshold is the similarity from which two
+	// fingerprints are taken to be the same recording. Unrelated audio
+	// scores around 0.6.
+	FingerprintMatchThreshold = 0.8
+	// duplicateMaxDurationDiff is how far apart in seconds two files can be
+	// and still be compared as duplicates.
+	duplicateMaxDurationDiff = 10.0
+)
+
+var fingerprintExts = map[string]bool{
+	".flac": true, ".mp3": true, ".m4a": true, ".wav": true, ".ogg": true, ".opus": true,
+}
+
+// Fingerprint is a chroma fingerprint of the first three minutes of a track:
+// one 24-bit hash every 0.125 s. Twelve bits say which pitch classes are
+// stronger than their neighbour and twelve which are above the frame's
+// average, so the hashes survive re-encoding, resampling and gain changes.
+type Fingerprint struct {
+	Duration float64  `json:"duration"`
+	Hashes   []uint32 `json:"hashes"`
+}
+
+// FingerprintMatch is the best alignment of two fingerprints. Offset is how
+// many seconds later the second one starts.
+type FingerprintMatch struct {
+	Similarity float64 `json:"similarity"`
+	Offset     float64 `json:"offset"`
+	Match      bool    `json:"match"`
+}
+
+// ComputeFingerprint decodes path and fingerprints it.
+func ComputeFingerprint(path string) (*Fingerprint, error) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	sink := &fingerprintSink{done: stop}
+	format, err := analyzeFileContext(ctx, path, sink)
+	if err != nil && !sink.full() {
+		return nil, err
+	}
+	if len(sink.hashes) == 0 {
+		return nil, fmt.Errorf("audio is too short to fingerprint")
+	}
+
+	duration := float64(sink.position) / float64(format.SampleRate)
+	if format.TotalSamples > 0 {
+		duration = float64(format.TotalSamples) / float64(format.SampleRate)
+	}
+	return &Fingerprint{Duration: duration, Hashes: sink.hashes}, nil
+}
+
+// FingerprintFile returns the fingerprint of path from the local index,
+// computing and storing it when the file is new or has changed.
+func FingerprintFile(path string) (*Fingerprint, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("file does not exist: %s", path)
+	}
+
+	var fp Fingerprint
+	if loadFileCache(fingerprintBucket, path, fingerprintVersion, info, &fp) {
+		return &fp, nil
+	}
+
+	computed, err := ComputeFingerprint(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveFileCache(fingerprintBucket, path, fingerprintVersion, info, computed); err != nil {
+		fmt.Printf("Failed to index fingerprint of %s: %v\n", path, err)
+	}
+	return computed, nil
+}
+
+// ClearFingerprintIndex drops every stored fingerprint.
+func ClearFingerprintIndex() error {
+	return clearFileCache(fingerprintBucket)
+}
+
+// fingerprintSink computes chroma hashes from a mono mix, one frame at a
+// time, and cancels the decode through done once it has enough.
+type fingerprintSink struct {
+	done func()
+
+	started  bool
+	gain     float64
+	hop      int
+	maxHash  int
+	plan     *fftPlan
+	window   []float64
+	classes  []int
+	pending  []float64
+	position uint64
+
+	x      []complex128
+	chroma [12]float64
+	hashes []uint32
+}
+
+func (s *fingerprintSink) start(format audioFormat) {
+	s.started = true
+	s.gain = format.scale()
+
+	size := 1
+	for float64(size) < fingerprintFrameSeconds*float64(format.SampleRate) {
+		size <<= 1
+	}
+	s.hop = int(math.Round(fingerprintHopSeconds * float64(format.SampleRate)))
+	s.maxHash = int(fingerprintMaxSeconds / fingerprintHopSeconds)
+	s.plan = getFFTPlan(size)
+	s.window = spectrumWindow(SpectrumWindowHann, size)
+	s.pending = make([]float64, 0, size)
+	s.x = make([]complex128, size)
+
+	// Map every FFT bin in range to its pitch class, A = 0.
+	binHz := float64(format.SampleRate) / float64(size)
+	s.classes = make([]int, size/2)
+	for k := range s.classes {
+		hz := float64(k) * binHz
+		if hz < fingerprintMinHz || hz > fingerprintMaxHz {
+			s.classes[k] = -1
+			continue
+		}
+		note := int(math.Round(12 * math.Log2(hz/440)))
+		s.classes[k] = ((note % 12) + 12) % 12
+	}
+}
+
+func (s *fingerprintSink) full() bool {
+	return s.started && len(s.hashes) >= s.maxHash
+}
+
+func (s *fingerprintSink) Write(block [][]int32, format audioFormat) {
+	if !s.started {
+		s.start(format)
+	}
+	if s.full() {
+		return
+	}
+
+	channels := float64(len(block))
+	for i := range block[0] {
+		var sample float64
+		for ch := range block {
+			sample += float64(block[ch][i])
+		}
+		s.pending = append(s.pending, sample/channels*s.gain)
+		s.position++
+
+		if len(s.pending) == cap(s.pending) {
+			s.frame()
+			if s.full() {
+				s.done()
+				return
+			}
+			s.pending = s.pending[:copy(s.pending, s.pending[s.hop:])]
+		}
+	}
+}
+
+func (s *fingerprintSink) frame() {
+	for i, v := range s.pending {
+		s.x[i] = complex(v*s.window[i], 0)
+	}
+	s.plan.transform(s.x)
+
+	s.chroma = [12]float64{}
+	var total float64
+	for k, class := range s.classes {
+		if class < 0 {
+			continue
+		}
+		re, im := real(s.x[k]), imag(s.x[k])
+		s.chroma[class] += re*re + im*im
+		total += re*re + im*im
+	}
+	if total > 0 {
+		for i := range s.chroma {
+			s.chroma[i] /= total
+		}
+	}
+
+	var hash uint32
+	for i := 0; i < 12; i++ {
+		if s.chroma[i] > s.chroma[(i+1)%12] {
+			hash |= 1 << i
+		}
+		if s.chroma[i] > 1.0/12 {
+			hash |= 1 << (12 + i)
+		}
+	}
+	s.hashes = append(s.hashes, hash)
+}
+
+// CompareFingerprints finds the offset at which a and b agree best and
+// reports the share of matching hash bits there.
+func CompareFingerprints(a, b *Fingerprint) FingerprintMatch {
+	best := FingerprintMatch{}
+	bestOffset := 0
+
+	minOverlap := min(fingerprintMinOverlap, len(a.Hashes), len(b.Hashes))
+	for offset := -fingerprintMaxOffset; offset <= fingerprintMaxOffset; offset++ {
+		// b[j] lines up with a[j+offset].
+		start := max(0, -offset)
+		end := min(len(b.Hashes), len(a.Hashes)-offset)
+		if end-start < max(minOverlap, 1) {
+			continue
+		}
+
+		var diff int
+		for j := start; j < end; j++ {
+			diff += bits.OnesCount32(a.Hashes[j+offset] ^ b.Hashes[j])
+		}
+		similarity := 1 - float64(diff)/float64((end-start)*fingerprintBits)
+		if similarity > best.Similarity {
+			best.Similarity = similarity
+			bestOffset = offset
+		}
+	}
+
+	best.Offset = -float64(bestOffset) * fingerprintHopSeconds
+	best.Match = best.Similarity >= FingerprintMatchThreshold
+	return best
+}
+
+// Encode packs the fingerprint into a string that can be kept as a
+// reference and passed back to DecodeFingerprint.
+func (f *Fingerprint) Encode() string {
+	buf := make([]byte, 1+8+4*len(f.Hashes))
+	buf[0] = fingerprintVersion
+	binary.LittleEndian.PutUint64(buf[1:], math.Float64bits(f.Duration))
+	for i, h := range f.Hashes {
+		binary.LittleEndian.PutUint32(buf[9+4*i:], h)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func DecodeFingerprint(encoded string) (*Fingerprint, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid fingerprint: %w", err)
+	}
+	if len(buf) < 9 || (len(buf)-9)%4 != 0 || buf[0] != fingerprintVersion {
+		return nil, fmt.Errorf("invalid fingerprint")
+	}
+
+	f := &Fingerprint{
+		Duration: math.Float64frombits(binary.LittleEndian.Uint64(buf[1:])),
+		Hashes:   make([]uint32, (len(buf)-9)/4),
+	}
+	for i := range f.Hashes {
+		f.Hashes[i] = binary.LittleEndian.Uint32(buf[9+4*i:])
+	}
+	return f, nil
+}
+
+// FingerprintComparison is the result of comparing a file against another
+// file or a stored fingerprint.
+type FingerprintComparison struct {
+	FilePath      string  `json:"file_path"`
+	ReferencePath string  `json:"reference_path,omitempty"`
+	Duration      float64 `json:"duration"`
+	RefDuration   float64 `json:"reference_duration"`
+	FingerprintMatch
+}
+
+// CompareAudioFiles tells whether two files hold the same recording, for
+// checking a fresh download against a copy already in the library.
+func CompareAudioFiles(path, referencePath string) (*FingerprintComparison, error) {
+	ref, err := FingerprintFile(referencePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint %s: %w", referencePath, err)
+	}
+	comparison, err := CompareWithFingerprint(path, ref)
+	if err != nil {
+		return nil, err
+	}
+	comparison.ReferencePath = referencePath
+	return comparison, nil
+}
+
+// CompareWithFingerprint compares path against a reference fingerprint.
+func CompareWithFingerprint(path string, ref *Fingerprint) (*FingerprintComparison, error) {
+	fp, err := FingerprintFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint %s: %w", path, err)
+	}
+	return &FingerprintComparison{
+		FilePath:         path,
+		Duration:         fp.Duration,
+		RefDuration:      ref.Duration,
+		FingerprintMatch: CompareFingerprints(ref, fp),
+	}, nil
+}
+
+// DuplicateFile is one copy in a DuplicateGroup.
+type DuplicateFile struct {
+	FilePath string  `json:"file_path"`
+	Size     int64   `json:"size"`
+	Duration float64 `json:"duration"`
+}
+
+// DuplicateGroup is a set of files that hold the same recording.
+// Similarity is the lowest score between any two files joined in the group.
+type DuplicateGroup struct {
+	Files      []DuplicateFile `json:"files"`
+	Similarity float64         `json:"similarity"`
+}
+
+// FindDuplicates fingerprints every audio file under dir and groups the ones
+// that hold the same recording. Files that cannot be decoded are skipped.
+func FindDuplicates(dir string) ([]DuplicateGroup, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && fingerprintExts[strings.ToLower(filepath.Ext(path))] {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", dir, err)
+	}
+
+	prints := make([]*Fingerprint, len(paths))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(1, runtime.NumCPU()))
+	for i, path := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fp, err := FingerprintFile(path)
+			if err != nil {
+				fmt.Printf("Skipping %s: %v\n", path, err)
+				return
+			}
+			prints[i] = fp
+		}(i, path)
+	}
+	wg.Wait()
+
+	// Union-find over every pair close enough in length to be compared.
+	parent := make([]int, len(paths))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	order := make([]int, 0, len(paths))
+	for i, fp := range prints {
+		if fp != nil {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(x, y int) bool {
+		return prints[order[x]].Duration < prints[order[y]].Duration
+	})
+
+	similarity := make(map[int]float64)
+	for x, i := range order {
+		for _, j := range order[x+1:] {
+			if prints[j].Duration-prints[i].Duration > duplicateMaxDurationDiff {
+				break
+			}
+			match := CompareFingerprints(prints[i], prints[j])
+			if !match.Match {
+				continue
+			}
+
+			ri, rj := find(i), find(j)
+			lowest := match.Similarity
+			for _, r := range []int{ri, rj} {
+				if s, ok := similarity[r]; ok && s < lowest {
+					lowest = s
+				}
+			}
+			if ri != rj {
+				parent[rj] = ri
+				delete(similarity, rj)
+			}
+			similarity[ri] = lowest
+		}
+	}
+
+	members := make(map[int][]int)
+	for _, i := range order {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+
+	var groups []DuplicateGroup
+	for root, indexes := range members {
+		if len(indexes) < 2 {
+			continue
+		}
+		group := DuplicateGroup{Similarity: similarity[root]}
+		for _, i := range indexes {
+			file := DuplicateFile{FilePath: paths[i], Duration: prints[i].Duration}
+			if info, err := os.Stat(paths[i]); err == nil {
+				file.Size = info.Size()
+			}
+			group.Files = append(group.Files, file)
+		}
+		sort.Slice(group.Files, func(x, y int) bool {
+			return group.Files[x].FilePath < group.Files[y].FilePath
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(x, y int) bool {
+		return groups[x].Files[0].FilePath < groups[y].Files[0].FilePath
+	})
+
+	return groups, nil
+}
@@ -12,17 +12,23 @@ import (
 )
 
 type HistoryItem struct {
-	ID          string `json:"id"`
-	SpotifyID   string `json:"spotify_id"`
-	Title       string `json:"title"`
-	Artists     string `json:"artists"`
-	Album       string `json:"album"`
-	DurationStr string `json:"duration_str"`
-	CoverURL    string `json:"cover_url"`
-	Quality     string `json:"quality"`
-	Format      string `json:"format"`
-	Path        string `json:"path"`
-	Timestamp   int64  `json:"timestamp"`
+	ID          string   `json:"id"`
+	SpotifyID   string   `json:"spotify_id"`
+	Title       string   `json:"title"`
+	Artists     string   `json:"artists"`
+	Album       string   `json:"album"`
+	DurationStr string   `json:"duration_str"`
+	CoverURL    string   `json:"cover_url"`
+	Quality     string   `json:"quality"`
+	Format      string   `json:"format"`
+	Path        string   `json:"path"`
+	Provider    string   `json:"provider,omitempty"`
+	Downgraded  bool     `json:"downgraded,omitempty"`
+	Unverified  bool     `json:"unverified,omitempty"`
+	LossySource string   `json:"lossy_source,omitempty"`
+	Effective   string   `json:"effective_format,omitempty"`
+	Defects     []string `json:"defects,omitempty"`
+	Timestamp   int64    `json:"timestamp"`
 }
 
 var historyDB *bolt.DB
@@ -49,7 +55,10 @@ func InitHistoryDB(appName string) error {
 	}
 
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(historyBucket))
+		if _, err := tx.CreateBucketIfNotExists([]byte(historyBucket)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte(downloadQueueBucket))
 		return err
 	})
 
@@ -294,6 +303,56 @@ func ClearFetchHistoryByType(itemType string, appName string) error {
 	})
 }
 
+// SetHistoryDefects records the defects found in each file, keyed by path,
+// on every history entry that points at it. The whole batch is written in
+// one transaction after a single pass over the history.
+func SetHistoryDefects(defects map[string][]string, appName string) error {
+	if len(defects) == 0 {
+		return nil
+	}
+	if historyDB == nil {
+		if err := InitHistoryDB(appName); err != nil {
+			return err
+		}
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(historyBucket))
+		if b == nil {
+			return nil
+		}
+
+		items := make(map[string]HistoryItem)
+		keysByPath := make(map[string][]string)
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var item HistoryItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				continue
+			}
+			if _, ok := defects[item.Path]; !ok {
+				continue
+			}
+			items[string(k)] = item
+			keysByPath[item.Path] = append(keysByPath[item.Path], string(k))
+		}
+
+		for path, keys := range keysByPath {
+			for _, k := range keys {
+				item := items[k]
+				item.Defects = defects[path]
+				buf, err := json.Marshal(item)
+				if err != nil {
+					return err
+				}
+				if err := b.Put([]byte(k), buf); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
 func DeleteHistoryItem(id string, appName string) error {
 	if historyDB == nil {
 		if err := InitHistoryDB(appName); err != nil {
@@ -324,3 +383,91 @@ func DeleteFetchHistoryItem(id string, appName string) error {
 		return b.Delete([]byte(id))
 	})
 }
+
+const (
+	downloadQueueBucket = "DownloadQueue"
+)
+
+func saveQueueItems(items ...DownloadItem) error {
+	if historyDB == nil || len(items) == 0 {
+		return nil
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(downloadQueueBucket))
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			buf, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(item.ID), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func deleteQueueItems(ids ...string) error {
+	if historyDB == nil || len(ids) == 0 {
+		return nil
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(downloadQueueBucket))
+		if b == nil {
+			return nil
+		}
+		for _, id := range ids {
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func clearQueueItems() error {
+	if historyDB == nil {
+		return nil
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(downloadQueueBucket)) == nil {
+			return nil
+		}
+		if err := tx.DeleteBucket([]byte(downloadQueueBucket)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket([]byte(downloadQueueBucket))
+		return err
+	})
+}
+
+func loadQueueItems() ([]DownloadItem, error) {
+	if historyDB == nil {
+		return nil, nil
+	}
+	var items []DownloadItem
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(downloadQueueBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var item DownloadItem
+			if err := json.Unmarshal(v, &item); err == nil {
+				items = append(items, item)
+			}
+		}
+		return nil
+	})
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].AddedAt < items[j].AddedAt
+	})
+
+	return items, err
+}
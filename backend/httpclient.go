@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dialTimeout           = 15 * time.Second
+	tlsHandshakeTimeout   = 15 * time.Second
+	responseHeaderTimeout = 30 * time.Second
+	downloadIdleTimeout   = 30 * time.Second
+)
+
+// sharedTransport bounds every phase of a request up to the response headers.
+// It deliberately has no overall deadline: audio bodies can take minutes on a
+// slow link, so body reads are guarded by an idle watchdog instead.
+var sharedTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	TLSHandshakeTimeout:   tlsHandshakeTimeout,
+	ResponseHeaderTimeout: responseHeaderTimeout,
+	ExpectContinueTimeout: 1 * time.Second,
+	IdleConnTimeout:       90 * time.Second,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   16,
+}
+
+// newAPIClient returns a client on the shared transport for small API
+// responses, where a total deadline is still the right limit.
+func newAPIClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: sharedTransport, Timeout: timeout}
+}
+
+// newDownloadClient returns a client for file transfers. It has no total
+// timeout; wrap response bodies with newIdleTimeoutReader so a stalled
+// connection is still dropped.
+func newDownloadClient() *http.Client {
+	return &http.Client{Transport: sharedTransport}
+}
+
+type stalledDownloadError struct {
+	timeout time.Duration
+}
+
+func (e *stalledDownloadError) Error() string {
+	return fmt.Sprintf("download stalled: no data received for %v", e.timeout)
+}
+
+// idleTimeoutReader closes the underlying body when no Read has returned data
+// for the configured timeout, which unblocks a pending Read with an error.
+type idleTimeoutReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	fired   atomic.Bool
+	once    sync.Once
+}
+
+func newIdleTimeoutReader(body io.ReadCloser, timeout time.Duration) *idleTimeoutReader {
+	r := &idleTimeoutReader{body: body, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		r.fired.Store(true)
+		body.Close()
+	})
+	return r
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if r.fired.Load() {
+		return n, &stalledDownloadError{timeout: r.timeout}
+	}
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+func (r *idleTimeoutReader) Close() error {
+	var err error
+	r.once.Do(func() {
+		r.timer.Stop()
+		err = r.body.Close()
+	})
+	return err
+}
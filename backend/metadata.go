@@ -8,6 +8,7 @@ import (
 	pathfilepath "path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	id3v2 "github.com/bogem/id3v2/v2"
 	"github.com/go-flac/flacpicture"
@@ -31,6 +32,7 @@ type Metadata struct {
 	Publisher   string
 	Lyrics      string
 	Description string
+	ISRC        string
 }
 
 func EmbedMetadata(filepath string, metadata Metadata, coverPath string) error {
@@ -85,6 +87,12 @@ func EmbedMetadata(filepath string, metadata Metadata, coverPath string) error {
 	if metadata.Description != "" {
 		_ = cmt.Add("DESCRIPTION", metadata.Description)
 	}
+	if metadata.ISRC != "" {
+		_ = cmt.Add("ISRC", metadata.ISRC)
+	}
+	if metadata.URL != "" {
+		_ = cmt.Add("URL", metadata.URL)
+	}
 
 	if metadata.Lyrics != "" {
 		_ = cmt.Add("LYRICS", metadata.Lyrics)
@@ -139,6 +147,44 @@ func embedCoverArt(f *flac.File, coverPath string) error {
 	return nil
 }
 
+// tagWriteLock is the lock of one file, dropped from tagWriteLocks once no
+// one holds or waits for it.
+type tagWriteLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var (
+	tagWriteLocks   = make(map[string]*tagWriteLock)
+	tagWriteLocksMu sync.Mutex
+)
+
+// lockTagWrites serializes read-modify-write tag updates on one file, such as
+// lyrics and ReplayGain being written from different goroutines after a
+// download. It returns the unlock function.
+func lockTagWrites(path string) func() {
+	tagWriteLocksMu.Lock()
+	lock, ok := tagWriteLocks[path]
+	if !ok {
+		lock = &tagWriteLock{}
+		tagWriteLocks[path] = lock
+	}
+	lock.refs++
+	tagWriteLocksMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		tagWriteLocksMu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(tagWriteLocks, path)
+		}
+		tagWriteLocksMu.Unlock()
+	}
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -159,6 +205,8 @@ func EmbedLyricsOnly(filepath string, lyrics string) error {
 	if lyrics == "" {
 		return nil
 	}
+	defer lockTagWrites(filepath)()
+
 	f, err := flac.ParseFile(filepath)
 	if err != nil {
 		return fmt.Errorf("failed to parse FLAC file: %w", err)
@@ -773,6 +821,8 @@ func ExtractFullMetadataFromFile(filePath string) (Metadata, error) {
 			metadata.Publisher = value
 		case "url":
 			metadata.URL = value
+		case "isrc", "tsrc":
+			metadata.ISRC = value
 		case "description", "comment":
 			if metadata.Description == "" {
 				metadata.Description = value
@@ -858,6 +908,11 @@ func embedMetadataToMP3(filePath string, metadata Metadata, coverPath string) er
 		tag.AddTextFrame("TPUB", id3v2.EncodingUTF8, metadata.Publisher)
 	}
 
+	if metadata.ISRC != "" {
+		tag.DeleteFrames("TSRC")
+		tag.AddTextFrame("TSRC", id3v2.EncodingUTF8, metadata.ISRC)
+	}
+
 	if coverPath != "" && fileExists(coverPath) {
 
 		tag.DeleteFrames(tag.CommonID("Attached picture"))
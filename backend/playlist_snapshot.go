@@ -0,0 +1,372 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	playlistSnapshotsBucket = "PlaylistSnapshots"
+	maxPlaylistSnapshots    = 200
+)
+
+// PlaylistSnapshot is the track list of a playlist as fetched at one point
+// in time. A fetch that finds the same tracks in the same order as the latest
+// snapshot only moves its CheckedAt forward, so every version is a change.
+type PlaylistSnapshot struct {
+	PlaylistID string          `json:"playlist_id"`
+	Version    int             `json:"version"`
+	Name       string          `json:"name"`
+	Owner      string          `json:"owner,omitempty"`
+	FetchedAt  int64           `json:"fetched_at"`
+	CheckedAt  int64           `json:"checked_at"`
+	Tracks     []SnapshotTrack `json:"tracks,omitempty"`
+	TrackCount int             `json:"track_count"`
+}
+
+// SnapshotTrack keeps enough of a track to find it again once it has been
+// dropped from the playlist. AddedAt is Spotify's ISO 8601 timestamp.
+type SnapshotTrack struct {
+	SpotifyID string `json:"spotify_id"`
+	Name      string `json:"name"`
+	Artists   string `json:"artists"`
+	Album     string `json:"album,omitempty"`
+	AlbumID   string `json:"album_id,omitempty"`
+	AddedAt   string `json:"added_at,omitempty"`
+}
+
+// PlaylistDiffTrack is a track in a diff. Positions are 1-based; the one for
+// the side the track is missing from is 0.
+type PlaylistDiffTrack struct {
+	SnapshotTrack
+	FromPosition int `json:"from_position,omitempty"`
+	ToPosition   int `json:"to_position,omitempty"`
+}
+
+// PlaylistDiff compares two snapshots of a playlist. Reordered lists the
+// tracks present in both that moved relative to the others, not every track
+// whose index shifted because of an insert or removal above it.
+type PlaylistDiff struct {
+	PlaylistID    string              `json:"playlist_id"`
+	From          int                 `json:"from"`
+	To            int                 `json:"to"`
+	FromFetchedAt int64               `json:"from_fetched_at"`
+	ToFetchedAt   int64               `json:"to_fetched_at"`
+	Added         []PlaylistDiffTrack `json:"added"`
+	Removed       []PlaylistDiffTrack `json:"removed"`
+	Reordered     []PlaylistDiffTrack `json:"reordered"`
+}
+
+// recordPlaylistSnapshot stores a fetched playlist as a new snapshot version.
+// Failures are logged rather than returned so they never fail the fetch.
+func recordPlaylistSnapshot(playlistID string, payload PlaylistResponsePayload) {
+	if historyDB == nil || playlistID == "" {
+		return
+	}
+
+	now := time.Now().Unix()
+	snapshot := PlaylistSnapshot{
+		PlaylistID: playlistID,
+		Name:       payload.PlaylistInfo.Owner.Name,
+		Owner:      payload.PlaylistInfo.Owner.DisplayName,
+		FetchedAt:  now,
+		CheckedAt:  now,
+		Tracks:     make([]SnapshotTrack, 0, len(payload.TrackList)),
+	}
+	for _, track := range payload.TrackList {
+		snapshot.Tracks = append(snapshot.Tracks, SnapshotTrack{
+			SpotifyID: track.SpotifyID,
+			Name:      track.Name,
+			Artists:   track.Artists,
+			Album:     track.AlbumName,
+			AlbumID:   track.AlbumID,
+			AddedAt:   track.AddedAt,
+		})
+	}
+	snapshot.TrackCount = len(snapshot.Tracks)
+
+	err := historyDB.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists([]byte(playlistSnapshotsBucket))
+		if err != nil {
+			return err
+		}
+		b, err := root.CreateBucketIfNotExists([]byte(playlistID))
+		if err != nil {
+			return err
+		}
+
+		if k, v := b.Cursor().Last(); k != nil {
+			var latest PlaylistSnapshot
+			if err := json.Unmarshal(v, &latest); err == nil && sameTrackOrder(latest.Tracks, snapshot.Tracks) {
+				latest.CheckedAt = now
+				latest.Name = snapshot.Name
+				buf, err := json.Marshal(latest)
+				if err != nil {
+					return err
+				}
+				return b.Put(k, buf)
+			}
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		snapshot.Version = int(seq)
+		buf, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(snapshotKey(snapshot.Version), buf); err != nil {
+			return err
+		}
+
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+		for _, k := range keys[:max(0, len(keys)-maxPlaylistSnapshots)] {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Failed to record playlist snapshot for %s: %v\n", playlistID, err)
+	}
+}
+
+func snapshotKey(version int) []byte {
+	return []byte(fmt.Sprintf("%020d", version))
+}
+
+func sameTrackOrder(a, b []SnapshotTrack) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].SpotifyID != b[i].SpotifyID {
+			return false
+		}
+	}
+	return true
+}
+
+// GetPlaylistSnapshots lists a playlist's snapshots, newest first, without
+// their tracks.
+func GetPlaylistSnapshots(playlistID string) ([]PlaylistSnapshot, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history database not initialized")
+	}
+
+	var snapshots []PlaylistSnapshot
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := playlistSnapshotBucket(tx, playlistID)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var snapshot PlaylistSnapshot
+			if err := json.Unmarshal(v, &snapshot); err == nil {
+				snapshot.Tracks = nil
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+		return nil
+	})
+	return snapshots, err
+}
+
+// GetPlaylistSnapshot returns one version of a playlist, or the latest one
+// when version is 0.
+func GetPlaylistSnapshot(playlistID string, version int) (*PlaylistSnapshot, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history database not initialized")
+	}
+
+	var snapshot *PlaylistSnapshot
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := playlistSnapshotBucket(tx, playlistID)
+		if b == nil {
+			return nil
+		}
+		var data []byte
+		if version == 0 {
+			_, data = b.Cursor().Last()
+		} else {
+			data = b.Get(snapshotKey(version))
+		}
+		if data == nil {
+			return nil
+		}
+		snapshot = &PlaylistSnapshot{}
+		return json.Unmarshal(data, snapshot)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		if version == 0 {
+			return nil, fmt.Errorf("no snapshots for playlist %s", playlistID)
+		}
+		return nil, fmt.Errorf("snapshot %d of playlist %s not found", version, playlistID)
+	}
+	return snapshot, nil
+}
+
+// DeletePlaylistSnapshots drops every snapshot of a playlist.
+func DeletePlaylistSnapshots(playlistID string) error {
+	if historyDB == nil {
+		return fmt.Errorf("history database not initialized")
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(playlistSnapshotsBucket))
+		if root == nil || root.Bucket([]byte(playlistID)) == nil {
+			return nil
+		}
+		return root.DeleteBucket([]byte(playlistID))
+	})
+}
+
+func playlistSnapshotBucket(tx *bolt.Tx, playlistID string) *bolt.Bucket {
+	root := tx.Bucket([]byte(playlistSnapshotsBucket))
+	if root == nil {
+		return nil
+	}
+	return root.Bucket([]byte(playlistID))
+}
+
+// DiffPlaylistSnapshots compares two versions of a playlist. to 0 means the
+// latest version and from 0 the one before to.
+func DiffPlaylistSnapshots(playlistID string, from, to int) (*PlaylistDiff, error) {
+	toSnap, err := GetPlaylistSnapshot(playlistID, to)
+	if err != nil {
+		return nil, err
+	}
+	if from == 0 {
+		from = previousSnapshotVersion(playlistID, toSnap.Version)
+		if from == 0 {
+			return nil, fmt.Errorf("no snapshot of playlist %s before version %d", playlistID, toSnap.Version)
+		}
+	}
+	fromSnap, err := GetPlaylistSnapshot(playlistID, from)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := diffSnapshotTracks(fromSnap.Tracks, toSnap.Tracks)
+	diff.PlaylistID = playlistID
+	diff.From = fromSnap.Version
+	diff.To = toSnap.Version
+	diff.FromFetchedAt = fromSnap.FetchedAt
+	diff.ToFetchedAt = toSnap.FetchedAt
+	return diff, nil
+}
+
+func previousSnapshotVersion(playlistID string, version int) int {
+	prev := 0
+	_ = historyDB.View(func(tx *bolt.Tx) error {
+		b := playlistSnapshotBucket(tx, playlistID)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		c.Seek(snapshotKey(version))
+		if _, v := c.Prev(); v != nil {
+			var snapshot PlaylistSnapshot
+			if err := json.Unmarshal(v, &snapshot); err == nil {
+				prev = snapshot.Version
+			}
+		}
+		return nil
+	})
+	return prev
+}
+
+// diffSnapshotTracks matches the two track lists by Spotify ID, pairing
+// repeated entries of a track in order. Matched tracks that are not part of
+// the longest run kept in the same relative order are reported as reordered.
+func diffSnapshotTracks(from, to []SnapshotTrack) *PlaylistDiff {
+	diff := &PlaylistDiff{
+		Added:     []PlaylistDiffTrack{},
+		Removed:   []PlaylistDiffTrack{},
+		Reordered: []PlaylistDiffTrack{},
+	}
+
+	fromIndexes := make(map[string][]int)
+	for i, track := range from {
+		fromIndexes[track.SpotifyID] = append(fromIndexes[track.SpotifyID], i)
+	}
+
+	matched := make([]bool, len(from))
+	// pairs[k] is the from index of the k-th matched track, in to order.
+	var pairs, toPairs []int
+	for j, track := range to {
+		indexes := fromIndexes[track.SpotifyID]
+		if len(indexes) == 0 {
+			diff.Added = append(diff.Added, PlaylistDiffTrack{SnapshotTrack: track, ToPosition: j + 1})
+			continue
+		}
+		i := indexes[0]
+		fromIndexes[track.SpotifyID] = indexes[1:]
+		matched[i] = true
+		pairs = append(pairs, i)
+		toPairs = append(toPairs, j)
+	}
+
+	for i, track := range from {
+		if !matched[i] {
+			diff.Removed = append(diff.Removed, PlaylistDiffTrack{SnapshotTrack: track, FromPosition: i + 1})
+		}
+	}
+
+	stable := longestIncreasing(pairs)
+	for k, i := range pairs {
+		if stable[k] {
+			continue
+		}
+		diff.Reordered = append(diff.Reordered, PlaylistDiffTrack{
+			SnapshotTrack: to[toPairs[k]],
+			FromPosition:  i + 1,
+			ToPosition:    toPairs[k] + 1,
+		})
+	}
+	return diff
+}
+
+// longestIncreasing marks the elements of one longest strictly increasing
+// subsequence of seq.
+func longestIncreasing(seq []int) []bool {
+	// tails[l] is the index in seq of the smallest tail of an increasing
+	// subsequence of length l+1; prev links each element to its predecessor.
+	tails := make([]int, 0, len(seq))
+	prev := make([]int, len(seq))
+	for k, v := range seq {
+		l := sort.Search(len(tails), func(n int) bool { return seq[tails[n]] >= v })
+		if l > 0 {
+			prev[k] = tails[l-1]
+		} else {
+			prev[k] = -1
+		}
+		if l == len(tails) {
+			tails = append(tails, k)
+		} else {
+			tails[l] = k
+		}
+	}
+
+	keep := make([]bool, len(seq))
+	if len(tails) > 0 {
+		for k := tails[len(tails)-1]; k >= 0; k = prev[k] {
+			keep[k] = true
+		}
+	}
+	return keep
+}
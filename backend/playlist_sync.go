@@ -0,0 +1,729 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	id3v2 "github.com/bogem/id3v2/v2"
+	"github.com/go-flac/flacvorbis"
+	"github.com/go-flac/go-flac"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	syncTargetsBucket = "SyncTargets"
+	syncReportsBucket = "SyncReports"
+	maxSyncReports    = 50
+	syncArchiveFolder = "Removed"
+	// maxSyncRemovalShare is the share of a target's tracks one run may
+	// delete or archive without confirmation.
+	maxSyncRemovalShare = 0.5
+)
+
+// What a sync does with local tracks that were removed from the playlist.
+const (
+	SyncRemovedKeep    = "keep"
+	SyncRemovedArchive = "archive"
+	SyncRemovedDelete  = "delete"
+)
+
+// SyncTarget mirrors a Spotify playlist into OutputDir. Options is the
+// download request used as the template for new tracks, kept as JSON since
+// its type lives in the app. Tracks maps the Spotify IDs the target manages
+// to their local files and is maintained by the sync itself.
+type SyncTarget struct {
+	ID           string                 `json:"id"`
+	URL          string                 `json:"url"`
+	Name         string                 `json:"name,omitempty"`
+	OutputDir    string                 `json:"output_dir"`
+	RemovePolicy string                 `json:"remove_policy"`
+	ArchiveDir   string                 `json:"archive_dir,omitempty"`
+	Options      json.RawMessage        `json:"options,omitempty"`
+	Tracks       map[string]SyncedTrack `json:"tracks,omitempty"`
+	CreatedAt    int64                  `json:"created_at"`
+	LastSync     int64                  `json:"last_sync,omitempty"`
+}
+
+type SyncedTrack struct {
+	ISRC string `json:"isrc,omitempty"`
+	Path string `json:"path"`
+}
+
+func (t *SyncTarget) archiveDir() string {
+	if t.ArchiveDir != "" {
+		return t.ArchiveDir
+	}
+	return filepath.Join(t.OutputDir, syncArchiveFolder)
+}
+
+// SyncReport describes one SyncPlaylist run. It is saved when the run starts
+// and again once its downloads have finished. Held lists the removals a run
+// refused to apply without confirmation, and Error says why.
+type SyncReport struct {
+	TargetID   string            `json:"target_id"`
+	Playlist   string            `json:"playlist"`
+	StartedAt  int64             `json:"started_at"`
+	FinishedAt int64             `json:"finished_at,omitempty"`
+	Total      int               `json:"total"`
+	Present    int               `json:"present"`
+	Queued     []SyncReportTrack `json:"queued,omitempty"`
+	Downloaded int               `json:"downloaded"`
+	Failed     []SyncReportTrack `json:"failed,omitempty"`
+	Removed    []SyncRemoval     `json:"removed,omitempty"`
+	Held       []SyncRemoval     `json:"held,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+type SyncReportTrack struct {
+	SpotifyID string `json:"spotify_id"`
+	Name      string `json:"name"`
+	Artists   string `json:"artists"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SyncRemoval is a local track whose playlist entry is gone. Action is the
+// policy that was applied; Path is where the file ended up.
+type SyncRemoval struct {
+	SpotifyID string `json:"spotify_id"`
+	Path      string `json:"path"`
+	Action    string `json:"action"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SaveSyncTarget validates and stores target. A new target gets an ID; an
+// existing one keeps its track state, whatever the caller sent.
+func SaveSyncTarget(target SyncTarget) (*SyncTarget, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history database not initialized")
+	}
+
+	parsed, err := parseSpotifyURI(target.URL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Type != "playlist" {
+		return nil, fmt.Errorf("sync targets must be playlists, got %s", parsed.Type)
+	}
+	if strings.TrimSpace(target.OutputDir) == "" {
+		return nil, fmt.Errorf("output directory is required")
+	}
+	target.OutputDir = filepath.Clean(target.OutputDir)
+
+	switch target.RemovePolicy {
+	case "":
+		target.RemovePolicy = SyncRemovedKeep
+	case SyncRemovedKeep, SyncRemovedArchive, SyncRemovedDelete:
+	default:
+		return nil, fmt.Errorf("unknown remove policy: %s", target.RemovePolicy)
+	}
+
+	if target.ID == "" {
+		target.ID = fmt.Sprintf("%s-%d", parsed.ID, time.Now().UnixNano())
+		target.CreatedAt = time.Now().Unix()
+		target.Tracks = nil
+		target.LastSync = 0
+	} else {
+		existing, err := GetSyncTarget(target.ID)
+		if err != nil {
+			return nil, err
+		}
+		target.CreatedAt = existing.CreatedAt
+		target.Tracks = existing.Tracks
+		target.LastSync = existing.LastSync
+	}
+
+	if err := putSyncTarget(&target); err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+func putSyncTarget(target *SyncTarget) error {
+	buf, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(syncTargetsBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(target.ID), buf)
+	})
+}
+
+func GetSyncTarget(id string) (*SyncTarget, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history database not initialized")
+	}
+
+	var target *SyncTarget
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(syncTargetsBucket))
+		if b == nil {
+			return nil
+		}
+		if data := b.Get([]byte(id)); data != nil {
+			target = &SyncTarget{}
+			return json.Unmarshal(data, target)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, fmt.Errorf("sync target not found: %s", id)
+	}
+	return target, nil
+}
+
+func GetSyncTargets() ([]SyncTarget, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history database not initialized")
+	}
+
+	var targets []SyncTarget
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(syncTargetsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var target SyncTarget
+			if err := json.Unmarshal(v, &target); err == nil {
+				targets = append(targets, target)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].CreatedAt < targets[j].CreatedAt
+	})
+	return targets, nil
+}
+
+// DeleteSyncTarget forgets a target and its reports. Local files are kept.
+func DeleteSyncTarget(id string) error {
+	if historyDB == nil {
+		return fmt.Errorf("history database not initialized")
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(syncTargetsBucket)); b != nil {
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket([]byte(syncReportsBucket)); b != nil && b.Bucket([]byte(id)) != nil {
+			return b.DeleteBucket([]byte(id))
+		}
+		return nil
+	})
+}
+
+// GetSyncReports returns a target's reports, newest first.
+func GetSyncReports(targetID string) ([]SyncReport, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history database not initialized")
+	}
+
+	var reports []SyncReport
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(syncReportsBucket))
+		if b == nil {
+			return nil
+		}
+		rb := b.Bucket([]byte(targetID))
+		if rb == nil {
+			return nil
+		}
+		c := rb.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var report SyncReport
+			if err := json.Unmarshal(v, &report); err == nil {
+				reports = append(reports, report)
+			}
+		}
+		return nil
+	})
+	return reports, err
+}
+
+// saveSyncReport stores report under its target, keyed by start time so a
+// run's second save replaces its first.
+func saveSyncReport(report *SyncReport) error {
+	if historyDB == nil {
+		return nil
+	}
+
+	buf, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(syncReportsBucket))
+		if err != nil {
+			return err
+		}
+		rb, err := b.CreateBucketIfNotExists([]byte(report.TargetID))
+		if err != nil {
+			return err
+		}
+		if err := rb.Put([]byte(fmt.Sprintf("%020d", report.StartedAt)), buf); err != nil {
+			return err
+		}
+
+		var keys [][]byte
+		c := rb.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+		for _, k := range keys[:max(0, len(keys)-maxSyncReports)] {
+			if err := rb.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+var (
+	runningSyncs     = make(map[string]bool)
+	runningSyncsLock sync.Mutex
+)
+
+// PlaylistSync is a SyncPlaylist run whose downloads may still be going.
+// Missing indexes the tracks of Playlist that have no local file and need to
+// be queued; Finish must be called once they are done.
+type PlaylistSync struct {
+	Target   SyncTarget
+	Playlist *Collection
+	Missing  []int
+	Report   SyncReport
+
+	finishOnce sync.Once
+}
+
+// StartPlaylistSync fetches the target's playlist, matches its tracks against
+// the files in OutputDir and applies the remove policy to local tracks that
+// are no longer listed. A track is present when the file the target recorded
+// for it still exists, or when a file in OutputDir is tagged with its Spotify
+// URL or its ISRC. The ISRC is the one a previous run saw on the track, or
+// is looked up for tracks that have none while untaken ISRC-tagged files
+// remain.
+//
+// An empty playlist, or one that would delete or archive more than half of
+// the target's tracks, is more likely a failed or truncated fetch than a real
+// edit, so those removals are held and reported unless confirmRemovals is set.
+func StartPlaylistSync(ctx context.Context, id string, confirmRemovals bool) (*PlaylistSync, error) {
+	runningSyncsLock.Lock()
+	if runningSyncs[id] {
+		runningSyncsLock.Unlock()
+		return nil, fmt.Errorf("sync already running for %s", id)
+	}
+	runningSyncs[id] = true
+	runningSyncsLock.Unlock()
+
+	run, err := startPlaylistSync(ctx, id, confirmRemovals)
+	if err != nil {
+		runningSyncsLock.Lock()
+		delete(runningSyncs, id)
+		runningSyncsLock.Unlock()
+		return nil, err
+	}
+	return run, nil
+}
+
+func startPlaylistSync(ctx context.Context, id string, confirmRemovals bool) (*PlaylistSync, error) {
+	target, err := GetSyncTarget(id)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseSpotifyURI(target.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	client := NewSpotifyMetadataClient()
+	raw, err := client.fetchPlaylist(ctx, parsed.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+	payload := client.formatPlaylistData(raw)
+	recordPlaylistSnapshot(parsed.ID, payload)
+	playlist := playlistCollection(payload)
+
+	if err := os.MkdirAll(target.OutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	byID, byISRC := scanSyncFolder(target.OutputDir, target.archiveDir())
+
+	run := &PlaylistSync{
+		Playlist: playlist,
+		Report: SyncReport{
+			TargetID:  target.ID,
+			Playlist:  playlist.Name,
+			StartedAt: time.Now().UnixNano(),
+		},
+	}
+
+	tracks := make(map[string]SyncedTrack)
+	claimed := make(map[string]bool)
+	for i, track := range playlist.Tracks {
+		if _, seen := tracks[track.SpotifyID]; seen {
+			continue
+		}
+		run.Report.Total++
+
+		known := target.Tracks[track.SpotifyID]
+		var local SyncedTrack
+		switch {
+		case known.Path != "" && fileExists(known.Path):
+			local = known
+		case byID[track.SpotifyID].Path != "":
+			local = byID[track.SpotifyID]
+		case known.ISRC != "" && byISRC[known.ISRC] != "":
+			local = SyncedTrack{ISRC: known.ISRC, Path: byISRC[known.ISRC]}
+		}
+
+		if local.Path == "" {
+			run.Missing = append(run.Missing, i)
+			tracks[track.SpotifyID] = SyncedTrack{ISRC: known.ISRC}
+			continue
+		}
+		if local.ISRC == "" {
+			local.ISRC = known.ISRC
+		}
+		tracks[track.SpotifyID] = local
+		claimed[local.Path] = true
+		run.Report.Present++
+	}
+
+	// A file tagged with an ISRC that no track claimed may still belong to a
+	// missing track whose ISRC the target has not seen yet, as on a first
+	// sync. Those ISRCs are looked up through SongLink and Deezer like the
+	// providers do, but only while such files are left, since the lookups are
+	// rate limited.
+	unclaimed := make(map[string]string)
+	for isrc, path := range byISRC {
+		if !claimed[path] {
+			unclaimed[isrc] = path
+		}
+	}
+	if len(unclaimed) > 0 {
+		songLink := NewSongLinkClient()
+		missing := make([]int, 0, len(run.Missing))
+		for _, i := range run.Missing {
+			track := playlist.Tracks[i]
+			if len(unclaimed) > 0 && ctx.Err() == nil && tracks[track.SpotifyID].ISRC == "" {
+				if isrc := lookupTrackISRC(songLink, track.SpotifyID); unclaimed[isrc] != "" {
+					tracks[track.SpotifyID] = SyncedTrack{ISRC: isrc, Path: unclaimed[isrc]}
+					delete(unclaimed, isrc)
+					run.Report.Present++
+					continue
+				}
+			}
+			missing = append(missing, i)
+		}
+		run.Missing = missing
+	}
+
+	var gone []string
+	for spotifyID := range target.Tracks {
+		if _, ok := tracks[spotifyID]; !ok {
+			gone = append(gone, spotifyID)
+		}
+	}
+	sort.Strings(gone)
+	if held := target.holdRemovals(len(playlist.Tracks), len(gone)); held != "" && !confirmRemovals {
+		for _, spotifyID := range gone {
+			tracks[spotifyID] = target.Tracks[spotifyID]
+			run.Report.Held = append(run.Report.Held, SyncRemoval{
+				SpotifyID: spotifyID,
+				Path:      target.Tracks[spotifyID].Path,
+				Action:    target.RemovePolicy,
+			})
+		}
+		run.Report.Error = held
+		gone = nil
+	}
+	for _, spotifyID := range gone {
+		removal := target.removeTrack(spotifyID, target.Tracks[spotifyID])
+		if removal.Error != "" {
+			tracks[spotifyID] = target.Tracks[spotifyID]
+		}
+		run.Report.Removed = append(run.Report.Removed, removal)
+	}
+
+	for _, i := range run.Missing {
+		track := playlist.Tracks[i]
+		run.Report.Queued = append(run.Report.Queued, SyncReportTrack{
+			SpotifyID: track.SpotifyID,
+			Name:      track.Name,
+			Artists:   track.Artists,
+		})
+	}
+
+	// Missing tracks stay out of the state until a download gives them a file.
+	for _, i := range run.Missing {
+		if tracks[playlist.Tracks[i].SpotifyID].Path == "" {
+			delete(tracks, playlist.Tracks[i].SpotifyID)
+		}
+	}
+	target.Tracks = tracks
+	if target.Name == "" {
+		target.Name = playlist.Name
+	}
+	if err := putSyncTarget(target); err != nil {
+		return nil, err
+	}
+	run.Target = *target
+
+	if err := saveSyncReport(&run.Report); err != nil {
+		fmt.Printf("Failed to save sync report: %v\n", err)
+	}
+	return run, nil
+}
+
+// holdRemovals says why removing gone of the target's tracks needs
+// confirmation, or returns "" when it does not. Only policies that touch
+// files are held.
+func (t *SyncTarget) holdRemovals(playlistTracks, gone int) string {
+	if gone == 0 || t.RemovePolicy == SyncRemovedKeep {
+		return ""
+	}
+	if playlistTracks == 0 {
+		return fmt.Sprintf("playlist came back empty; %d removals held until confirmed", gone)
+	}
+	if float64(gone) > float64(len(t.Tracks))*maxSyncRemovalShare {
+		return fmt.Sprintf("%d of %d tracks left the playlist; removals held until confirmed", gone, len(t.Tracks))
+	}
+	return ""
+}
+
+// removeTrack applies the remove policy to a track that left the playlist.
+func (t *SyncTarget) removeTrack(spotifyID string, track SyncedTrack) SyncRemoval {
+	removal := SyncRemoval{SpotifyID: spotifyID, Path: track.Path, Action: t.RemovePolicy}
+	if track.Path == "" || !fileExists(track.Path) {
+		removal.Action = SyncRemovedKeep
+		return removal
+	}
+
+	switch t.RemovePolicy {
+	case SyncRemovedDelete:
+		if err := os.Remove(track.Path); err != nil {
+			removal.Error = err.Error()
+		}
+	case SyncRemovedArchive:
+		dir := t.archiveDir()
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			removal.Error = err.Error()
+			break
+		}
+		dest := uniqueFilePath(filepath.Join(dir, filepath.Base(track.Path)))
+		if err := os.Rename(track.Path, dest); err != nil {
+			removal.Error = err.Error()
+			break
+		}
+		removal.Path = dest
+	}
+	return removal
+}
+
+func uniqueFilePath(path string) string {
+	if !fileExists(path) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if !fileExists(candidate) {
+			return candidate
+		}
+	}
+}
+
+// Finish records the files the run's downloads produced, in the same order
+// as Missing, and saves the final report. Empty IDs count as not queued. It
+// is meant to run once every download reached a final outcome; paused items
+// hold the run open until they are resumed, cancelled or cleared.
+func (s *PlaylistSync) Finish(itemIDs []string) *SyncReport {
+	s.finishOnce.Do(func() {
+		defer func() {
+			runningSyncsLock.Lock()
+			delete(runningSyncs, s.Target.ID)
+			runningSyncsLock.Unlock()
+		}()
+
+		tracks := s.Target.Tracks
+		for n, i := range s.Missing {
+			track := s.Playlist.Tracks[i]
+			failed := SyncReportTrack{SpotifyID: track.SpotifyID, Name: track.Name, Artists: track.Artists}
+
+			var item DownloadItem
+			queued := n < len(itemIDs) && itemIDs[n] != ""
+			ok := false
+			if queued {
+				item, ok = GetDownloadItem(itemIDs[n])
+			}
+			switch {
+			case !queued:
+				failed.Error = "not queued"
+			case !ok:
+				failed.Error = "removed from queue"
+			case item.Status == StatusCompleted || item.Status == StatusSkipped:
+				if item.FilePath != "" {
+					_, isrc := readTrackIdentity(item.FilePath)
+					tracks[track.SpotifyID] = SyncedTrack{ISRC: isrc, Path: item.FilePath}
+					if item.Status == StatusCompleted {
+						s.Report.Downloaded++
+					} else {
+						s.Report.Present++
+					}
+					continue
+				}
+				failed.Error = "no file recorded"
+			default:
+				failed.Error = item.ErrorMessage
+				if failed.Error == "" {
+					failed.Error = string(item.Status)
+				}
+			}
+			s.Report.Failed = append(s.Report.Failed, failed)
+		}
+
+		// The target may have been edited while downloads ran; keep those
+		// settings and only write back the state this run owns.
+		target := &s.Target
+		if stored, err := GetSyncTarget(s.Target.ID); err == nil {
+			target = stored
+		}
+		target.Tracks = tracks
+		target.LastSync = time.Now().Unix()
+		if err := putSyncTarget(target); err != nil {
+			fmt.Printf("Failed to save sync target %s: %v\n", target.ID, err)
+		}
+
+		s.Report.FinishedAt = time.Now().UnixNano()
+		if err := saveSyncReport(&s.Report); err != nil {
+			fmt.Printf("Failed to save sync report: %v\n", err)
+		}
+	})
+	return &s.Report
+}
+
+// scanSyncFolder indexes the audio files under dir by the Spotify ID and ISRC
+// in their tags, skipping the archive folder.
+func scanSyncFolder(dir, archiveDir string) (map[string]SyncedTrack, map[string]string) {
+	byID := make(map[string]SyncedTrack)
+	byISRC := make(map[string]string)
+
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path == archiveDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		spotifyID, isrc := readTrackIdentity(path)
+		if spotifyID != "" {
+			byID[spotifyID] = SyncedTrack{ISRC: isrc, Path: path}
+		}
+		if isrc != "" {
+			byISRC[isrc] = path
+		}
+		return nil
+	})
+	return byID, byISRC
+}
+
+// lookupTrackISRC finds a Spotify track's ISRC through its Deezer
+// counterpart, or returns "" when it cannot be found.
+func lookupTrackISRC(songLink *SongLinkClient, spotifyID string) string {
+	deezerURL, err := songLink.GetDeezerURLFromSpotify(spotifyID)
+	if err != nil {
+		return ""
+	}
+	isrc, err := GetDeezerISRC(deezerURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimSpace(isrc))
+}
+
+// readTrackIdentity returns the Spotify ID from a file's URL tag and its
+// ISRC. Only FLAC and MP3 are read; MP3 files carry no URL tag.
+func readTrackIdentity(path string) (spotifyID, isrc string) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".flac":
+		file, err := os.Open(path)
+		if err != nil {
+			return "", ""
+		}
+		defer file.Close()
+
+		f, err := flac.ParseMetadata(file)
+		if err != nil {
+			return "", ""
+		}
+		for _, block := range f.Meta {
+			if block.Type != flac.VorbisComment {
+				continue
+			}
+			cmt, err := flacvorbis.ParseFromMetaDataBlock(*block)
+			if err != nil {
+				continue
+			}
+			for _, comment := range cmt.Comments {
+				name, value, ok := strings.Cut(comment, "=")
+				if !ok {
+					continue
+				}
+				switch strings.ToUpper(name) {
+				case "URL":
+					spotifyID = spotifyTrackIDFromURL(value)
+				case "ISRC":
+					isrc = strings.ToUpper(strings.TrimSpace(value))
+				}
+			}
+		}
+	case ".mp3":
+		tag, err := id3v2.Open(path, id3v2.Options{Parse: true, ParseFrames: []string{"TSRC"}})
+		if err != nil {
+			return "", ""
+		}
+		defer tag.Close()
+		if frames := tag.GetFrames("TSRC"); len(frames) > 0 {
+			if textFrame, ok := frames[0].(id3v2.TextFrame); ok {
+				isrc = strings.ToUpper(strings.TrimSpace(textFrame.Text))
+			}
+		}
+	}
+	return spotifyID, isrc
+}
+
+func spotifyTrackIDFromURL(url string) string {
+	const prefix = "https://open.spotify.com/track/"
+	if !strings.HasPrefix(url, prefix) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(url, prefix), "?")
+	return id
+}
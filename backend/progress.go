@@ -15,6 +15,8 @@ const (
 	StatusCompleted   DownloadStatus = "completed"
 	StatusFailed      DownloadStatus = "failed"
 	StatusSkipped     DownloadStatus = "skipped"
+	StatusInterrupted DownloadStatus = "interrupted"
+	StatusPaused      DownloadStatus = "paused"
 )
 
 type DownloadItem struct {
@@ -31,24 +33,37 @@ type DownloadItem struct {
 	EndTime      int64          `json:"end_time"`
 	ErrorMessage string         `json:"error_message"`
 	FilePath     string         `json:"file_path"`
+	AddedAt      int64          `json:"added_at"`
+	Request      string         `json:"request,omitempty"`
+	Downgraded   bool           `json:"downgraded,omitempty"`
+	QualityNote  string         `json:"quality_note,omitempty"`
+	Unverified   bool           `json:"unverified,omitempty"`
+	VerifyNote   string         `json:"verify_note,omitempty"`
+	FakeHiRes    bool           `json:"fake_hi_res,omitempty"`
+	Effective    string         `json:"effective_format,omitempty"`
 }
 
 var (
-	currentProgress     float64
-	currentProgressLock sync.RWMutex
-	isDownloading       bool
-	downloadingLock     sync.RWMutex
-	currentSpeed        float64
-	speedLock           sync.RWMutex
+	// Progress and speed of transfers that have no queue item, such as the
+	// FFmpeg download. Queue items carry their own in DownloadItem.
+	untrackedProgress     float64
+	untrackedProgressLock sync.RWMutex
+	activeDownloads       int
+	downloadingLock       sync.RWMutex
+	untrackedSpeed        float64
+	speedLock             sync.RWMutex
 
 	downloadQueue       []DownloadItem
 	downloadQueueLock   sync.RWMutex
-	currentItemID       string
-	currentItemLock     sync.RWMutex
 	totalDownloaded     float64
 	totalDownloadedLock sync.RWMutex
 	sessionStartTime    int64
 	sessionStartLock    sync.RWMutex
+
+	// queuePersistLock is held across a change to the queue and its write
+	// to the history DB, so the stored items follow the order of the
+	// changes. It is always taken before downloadQueueLock.
+	queuePersistLock sync.Mutex
 )
 
 type ProgressInfo struct {
@@ -67,20 +82,20 @@ type DownloadQueueInfo struct {
 	CompletedCount   int            `json:"completed_count"`
 	FailedCount      int            `json:"failed_count"`
 	SkippedCount     int            `json:"skipped_count"`
+	InterruptedCount int            `json:"interrupted_count"`
+	PausedCount      int            `json:"paused_count"`
 }
 
+// GetDownloadProgress sums the progress and speed of every transfer in
+// flight, since several workers can be downloading at once.
 func GetDownloadProgress() ProgressInfo {
 	downloadingLock.RLock()
-	downloading := isDownloading
+	downloading := activeDownloads > 0
 	downloadingLock.RUnlock()
 
-	currentProgressLock.RLock()
-	progress := currentProgress
-	currentProgressLock.RUnlock()
-
-	speedLock.RLock()
-	speed := currentSpeed
-	speedLock.RUnlock()
+	downloadQueueLock.RLock()
+	progress, speed := activeTransfers()
+	downloadQueueLock.RUnlock()
 
 	return ProgressInfo{
 		IsDownloading: downloading,
@@ -89,25 +104,54 @@ func GetDownloadProgress() ProgressInfo {
 	}
 }
 
+// SetDownloadSpeed and SetDownloadProgress report a transfer that has no
+// queue item; downloads with one report through UpdateItemProgress.
 func SetDownloadSpeed(mbps float64) {
 	speedLock.Lock()
-	currentSpeed = mbps
+	untrackedSpeed = mbps
 	speedLock.Unlock()
 }
 
 func SetDownloadProgress(mbDownloaded float64) {
-	currentProgressLock.Lock()
-	currentProgress = mbDownloaded
-	currentProgressLock.Unlock()
+	untrackedProgressLock.Lock()
+	untrackedProgress = mbDownloaded
+	untrackedProgressLock.Unlock()
+}
+
+// activeTransfers adds up the items being downloaded and the untracked
+// transfer. The caller holds downloadQueueLock.
+func activeTransfers() (progress, speed float64) {
+	for _, item := range downloadQueue {
+		if item.Status == StatusDownloading {
+			progress += item.Progress
+			speed += item.Speed
+		}
+	}
+
+	untrackedProgressLock.RLock()
+	progress += untrackedProgress
+	untrackedProgressLock.RUnlock()
+
+	speedLock.RLock()
+	speed += untrackedSpeed
+	speedLock.RUnlock()
+	return progress, speed
 }
 
+// SetDownloading marks the start (true) or end (false) of one download.
+// Calls are counted so concurrent downloads keep the flag set until the last
+// one finishes.
 func SetDownloading(downloading bool) {
 	downloadingLock.Lock()
-	isDownloading = downloading
+	if downloading {
+		activeDownloads++
+	} else if activeDownloads > 0 {
+		activeDownloads--
+	}
+	idle := activeDownloads == 0
 	downloadingLock.Unlock()
 
-	if !downloading {
-
+	if idle {
 		SetDownloadProgress(0)
 		SetDownloadSpeed(0)
 	}
@@ -121,6 +165,7 @@ type ProgressWriter struct {
 	lastTime    int64
 	lastBytes   int64
 	itemID      string
+	expected    int64
 }
 
 func NewProgressWriter(writer io.Writer) *ProgressWriter {
@@ -160,15 +205,20 @@ func (pw *ProgressWriter) Write(p []byte) (int, error) {
 		var speedMBps float64
 		if timeDiff > 0 {
 			speedMBps = (bytesDiff / (1024 * 1024)) / timeDiff
-			SetDownloadSpeed(speedMBps)
 			fmt.Printf("\rDownloaded: %.2f MB (%.2f MB/s)", mbDownloaded, speedMBps)
 		} else {
 			fmt.Printf("\rDownloaded: %.2f MB", mbDownloaded)
 		}
 
-		SetDownloadProgress(mbDownloaded)
-
-		if pw.itemID != "" {
+		switch {
+		case pw.itemID == "":
+			if timeDiff > 0 {
+				SetDownloadSpeed(speedMBps)
+			}
+			SetDownloadProgress(mbDownloaded)
+		case pw.expected > 0:
+			UpdateItemProgressWithTotal(pw.itemID, mbDownloaded, speedMBps, float64(pw.expected)/(1024*1024))
+		default:
 			UpdateItemProgress(pw.itemID, mbDownloaded, speedMBps)
 		}
 
@@ -184,10 +234,38 @@ func (pw *ProgressWriter) GetTotal() int64 {
 	return pw.total
 }
 
-func AddToQueue(id, trackName, artistName, albumName, isrc string) {
+func persistQueueItems(items ...DownloadItem) {
+	if err := saveQueueItems(items...); err != nil {
+		fmt.Printf("Warning: failed to persist download queue: %v\n", err)
+	}
+}
+
+// changeQueueItem applies update to an item and persists the result.
+func changeQueueItem(id string, update func(item *DownloadItem)) (DownloadItem, bool) {
+	queuePersistLock.Lock()
+	defer queuePersistLock.Unlock()
+
+	item, ok := updateQueueItem(id, update)
+	if ok {
+		persistQueueItems(item)
+	}
+	return item, ok
+}
+
+func updateQueueItem(id string, update func(item *DownloadItem)) (DownloadItem, bool) {
 	downloadQueueLock.Lock()
 	defer downloadQueueLock.Unlock()
 
+	for i := range downloadQueue {
+		if downloadQueue[i].ID == id {
+			update(&downloadQueue[i])
+			return downloadQueue[i], true
+		}
+	}
+	return DownloadItem{}, false
+}
+
+func AddToQueue(id, trackName, artistName, albumName, isrc string) {
 	item := DownloadItem{
 		ID:         id,
 		TrackName:  trackName,
@@ -200,100 +278,263 @@ func AddToQueue(id, trackName, artistName, albumName, isrc string) {
 		Speed:      0,
 		StartTime:  0,
 		EndTime:    0,
+		AddedAt:    time.Now().UnixNano(),
 	}
 
+	queuePersistLock.Lock()
+	defer queuePersistLock.Unlock()
+
+	downloadQueueLock.Lock()
 	downloadQueue = append(downloadQueue, item)
+	downloadQueueLock.Unlock()
 
 	sessionStartLock.Lock()
 	if sessionStartTime == 0 {
 		sessionStartTime = time.Now().Unix()
 	}
 	sessionStartLock.Unlock()
+
+	persistQueueItems(item)
 }
 
-func StartDownloadItem(id string) {
-	downloadQueueLock.Lock()
-	defer downloadQueueLock.Unlock()
+func SetDownloadItemRequest(id, request string) {
+	changeQueueItem(id, func(item *DownloadItem) {
+		item.Request = request
+	})
+}
 
-	for i := range downloadQueue {
-		if downloadQueue[i].ID == id {
-			downloadQueue[i].Status = StatusDownloading
-			downloadQueue[i].StartTime = time.Now().Unix()
-			downloadQueue[i].Progress = 0
-			break
+func GetDownloadItem(id string) (DownloadItem, bool) {
+	downloadQueueLock.RLock()
+	defer downloadQueueLock.RUnlock()
+
+	for _, item := range downloadQueue {
+		if item.ID == id {
+			return item, true
 		}
 	}
+	return DownloadItem{}, false
+}
 
-	currentItemLock.Lock()
-	currentItemID = id
-	currentItemLock.Unlock()
+// StartDownloadItem moves a queued item to downloading. It reports false,
+// leaving the item alone, when the item is missing or no longer queued, such
+// as one paused or cancelled before a worker reached it.
+func StartDownloadItem(id string) bool {
+	queuePersistLock.Lock()
+	defer queuePersistLock.Unlock()
+
+	started := false
+	item, ok := updateQueueItem(id, func(item *DownloadItem) {
+		if item.Status != StatusQueued {
+			return
+		}
+		started = true
+		item.Status = StatusDownloading
+		item.StartTime = time.Now().Unix()
+		item.EndTime = 0
+		item.Progress = 0
+		item.ErrorMessage = ""
+		item.Downgraded = false
+		item.QualityNote = ""
+		item.Unverified = false
+		item.VerifyNote = ""
+		item.FakeHiRes = false
+		item.Effective = ""
+	})
+	if !ok || !started {
+		return false
+	}
+	persistQueueItems(item)
+	return true
 }
 
 func UpdateItemProgress(id string, progress, speed float64) {
-	downloadQueueLock.Lock()
-	defer downloadQueueLock.Unlock()
+	updateQueueItem(id, func(item *DownloadItem) {
+		item.Progress = progress
+		item.Speed = speed
+	})
+}
 
-	for i := range downloadQueue {
-		if downloadQueue[i].ID == id {
-			downloadQueue[i].Progress = progress
-			downloadQueue[i].Speed = speed
-			break
+// UpdateItemProgressWithTotal is UpdateItemProgress for downloads whose final
+// size is known or can be estimated; totalSize is reported in MB like progress.
+func UpdateItemProgressWithTotal(id string, progress, speed, totalSize float64) {
+	updateQueueItem(id, func(item *DownloadItem) {
+		item.Progress = progress
+		item.Speed = speed
+		item.TotalSize = totalSize
+	})
+}
+
+// GetCurrentItemID returns the most recently started item that is still
+// downloading. Use GetActiveItemIDs when several workers are running.
+func GetCurrentItemID() string {
+	downloadQueueLock.RLock()
+	defer downloadQueueLock.RUnlock()
+
+	var id string
+	var started int64
+	for _, item := range downloadQueue {
+		if item.Status == StatusDownloading && item.StartTime >= started {
+			id, started = item.ID, item.StartTime
 		}
 	}
+	return id
 }
 
-func GetCurrentItemID() string {
-	currentItemLock.RLock()
-	defer currentItemLock.RUnlock()
-	return currentItemID
+// GetActiveItemIDs returns the IDs of every item being downloaded.
+func GetActiveItemIDs() []string {
+	downloadQueueLock.RLock()
+	defer downloadQueueLock.RUnlock()
+
+	var ids []string
+	for _, item := range downloadQueue {
+		if item.Status == StatusDownloading {
+			ids = append(ids, item.ID)
+		}
+	}
+	return ids
 }
 
 func CompleteDownloadItem(id, filePath string, finalSize float64) {
-	downloadQueueLock.Lock()
-	defer downloadQueueLock.Unlock()
+	if _, ok := changeQueueItem(id, func(item *DownloadItem) {
+		item.Status = StatusCompleted
+		item.EndTime = time.Now().Unix()
+		item.FilePath = filePath
+		item.Progress = finalSize
+		item.TotalSize = finalSize
+		item.Speed = 0
+	}); ok {
+		totalDownloadedLock.Lock()
+		totalDownloaded += finalSize
+		totalDownloadedLock.Unlock()
+	}
+}
 
-	for i := range downloadQueue {
-		if downloadQueue[i].ID == id {
-			downloadQueue[i].Status = StatusCompleted
-			downloadQueue[i].EndTime = time.Now().Unix()
-			downloadQueue[i].FilePath = filePath
-			downloadQueue[i].Progress = finalSize
-			downloadQueue[i].TotalSize = finalSize
+// FlagDownloadItemDowngraded marks an item whose file is below the requested
+// minimum quality but was kept.
+func FlagDownloadItemDowngraded(id, note string) {
+	changeQueueItem(id, func(item *DownloadItem) {
+		item.Downgraded = true
+		item.QualityNote = note
+	})
+}
 
-			totalDownloadedLock.Lock()
-			totalDownloaded += finalSize
-			totalDownloadedLock.Unlock()
-			break
-		}
-	}
+// FlagDownloadItemUnverified marks an item whose file failed post-download
+// verification but was kept.
+func FlagDownloadItemUnverified(id, note string) {
+	changeQueueItem(id, func(item *DownloadItem) {
+		item.Unverified = true
+		item.VerifyNote = note
+	})
+}
+
+// FlagDownloadItemFakeHiRes marks a finished item whose file is padded or
+// upsampled, with effective describing what it really carries.
+func FlagDownloadItemFakeHiRes(id, effective string) {
+	changeQueueItem(id, func(item *DownloadItem) {
+		item.FakeHiRes = true
+		item.Effective = effective
+	})
 }
 
 func FailDownloadItem(id, errorMsg string) {
-	downloadQueueLock.Lock()
-	defer downloadQueueLock.Unlock()
+	changeQueueItem(id, func(item *DownloadItem) {
+		item.Status = StatusFailed
+		item.EndTime = time.Now().Unix()
+		item.ErrorMessage = errorMsg
+		item.Speed = 0
+	})
+}
 
-	for i := range downloadQueue {
-		if downloadQueue[i].ID == id {
-			downloadQueue[i].Status = StatusFailed
-			downloadQueue[i].EndTime = time.Now().Unix()
-			downloadQueue[i].ErrorMessage = errorMsg
-			break
+func SkipDownloadItem(id, filePath string) {
+	changeQueueItem(id, func(item *DownloadItem) {
+		item.Status = StatusSkipped
+		item.EndTime = time.Now().Unix()
+		item.FilePath = filePath
+		item.Speed = 0
+	})
+}
+
+// RequeueDownloadItem moves a failed, interrupted or paused item back to the
+// queued state so it can be downloaded again with its stored request.
+func RequeueDownloadItem(id string) bool {
+	queuePersistLock.Lock()
+	defer queuePersistLock.Unlock()
+
+	requeued := false
+	item, ok := updateQueueItem(id, func(item *DownloadItem) {
+		switch item.Status {
+		case StatusFailed, StatusInterrupted, StatusPaused:
+		default:
+			return
 		}
+		item.Status = StatusQueued
+		item.Progress = 0
+		item.Speed = 0
+		item.StartTime = 0
+		item.EndTime = 0
+		item.ErrorMessage = ""
+		requeued = true
+	})
+	if !ok || !requeued {
+		return false
 	}
+
+	sessionStartLock.Lock()
+	if sessionStartTime == 0 {
+		sessionStartTime = time.Now().Unix()
+	}
+	sessionStartLock.Unlock()
+
+	persistQueueItems(item)
+	return true
 }
 
-func SkipDownloadItem(id, filePath string) {
-	downloadQueueLock.Lock()
-	defer downloadQueueLock.Unlock()
+// LoadDownloadQueue restores the queue persisted in the history DB. Items that
+// were downloading when the app last exited are marked interrupted, as are
+// queued items with a stored request, since no worker will pick them up until
+// ResumeInterruptedDownloads submits them again.
+func LoadDownloadQueue() error {
+	queuePersistLock.Lock()
+	defer queuePersistLock.Unlock()
+
+	items, err := loadQueueItems()
+	if err != nil {
+		return err
+	}
 
-	for i := range downloadQueue {
-		if downloadQueue[i].ID == id {
-			downloadQueue[i].Status = StatusSkipped
-			downloadQueue[i].EndTime = time.Now().Unix()
-			downloadQueue[i].FilePath = filePath
-			break
+	var interrupted []DownloadItem
+	var total float64
+	var sessionStart int64
+	for i := range items {
+		if items[i].Status == StatusDownloading || (items[i].Status == StatusQueued && items[i].Request != "") {
+			items[i].Status = StatusInterrupted
+			items[i].Speed = 0
+			items[i].EndTime = time.Now().Unix()
+			items[i].ErrorMessage = "Interrupted"
+			interrupted = append(interrupted, items[i])
+		}
+		if items[i].Status == StatusCompleted {
+			total += items[i].TotalSize
+		}
+		if items[i].StartTime > 0 && (sessionStart == 0 || items[i].StartTime < sessionStart) {
+			sessionStart = items[i].StartTime
 		}
 	}
+
+	downloadQueueLock.Lock()
+	downloadQueue = items
+	downloadQueueLock.Unlock()
+
+	totalDownloadedLock.Lock()
+	totalDownloaded = total
+	totalDownloadedLock.Unlock()
+
+	sessionStartLock.Lock()
+	sessionStartTime = sessionStart
+	sessionStartLock.Unlock()
+
+	persistQueueItems(interrupted...)
+	return nil
 }
 
 func GetDownloadQueue() DownloadQueueInfo {
@@ -304,12 +545,10 @@ func GetDownloadQueue() DownloadQueueInfo {
 	defer downloadQueueLock.RUnlock()
 
 	downloadingLock.RLock()
-	downloading := isDownloading
+	downloading := activeDownloads > 0
 	downloadingLock.RUnlock()
 
-	speedLock.RLock()
-	speed := currentSpeed
-	speedLock.RUnlock()
+	_, speed := activeTransfers()
 
 	totalDownloadedLock.RLock()
 	total := totalDownloaded
@@ -319,7 +558,7 @@ func GetDownloadQueue() DownloadQueueInfo {
 	sessionStart := sessionStartTime
 	sessionStartLock.RUnlock()
 
-	var queued, completed, failed, skipped int
+	var queued, completed, failed, skipped, interrupted, paused int
 	for _, item := range downloadQueue {
 		switch item.Status {
 		case StatusQueued:
@@ -330,6 +569,10 @@ func GetDownloadQueue() DownloadQueueInfo {
 			failed++
 		case StatusSkipped:
 			skipped++
+		case StatusInterrupted:
+			interrupted++
+		case StatusPaused:
+			paused++
 		}
 	}
 
@@ -346,27 +589,46 @@ func GetDownloadQueue() DownloadQueueInfo {
 		CompletedCount:   completed,
 		FailedCount:      failed,
 		SkippedCount:     skipped,
+		InterruptedCount: interrupted,
+		PausedCount:      paused,
 	}
 }
 
 func ClearDownloadQueue() {
+	queuePersistLock.Lock()
+	defer queuePersistLock.Unlock()
+
 	downloadQueueLock.Lock()
 	defer downloadQueueLock.Unlock()
 
 	newQueue := make([]DownloadItem, 0)
+	var removed []string
 	for _, item := range downloadQueue {
-		if item.Status == StatusQueued || item.Status == StatusDownloading {
+		switch item.Status {
+		case StatusQueued, StatusDownloading, StatusInterrupted, StatusPaused:
 			newQueue = append(newQueue, item)
+		default:
+			removed = append(removed, item.ID)
 		}
 	}
 	downloadQueue = newQueue
+
+	if err := deleteQueueItems(removed...); err != nil {
+		fmt.Printf("Warning: failed to persist download queue: %v\n", err)
+	}
 }
 
 func ClearAllDownloads() {
+	queuePersistLock.Lock()
 	downloadQueueLock.Lock()
 	downloadQueue = []DownloadItem{}
 	downloadQueueLock.Unlock()
 
+	if err := clearQueueItems(); err != nil {
+		fmt.Printf("Warning: failed to persist download queue: %v\n", err)
+	}
+	queuePersistLock.Unlock()
+
 	totalDownloadedLock.Lock()
 	totalDownloaded = 0
 	totalDownloadedLock.Unlock()
@@ -375,25 +637,27 @@ func ClearAllDownloads() {
 	sessionStartTime = 0
 	sessionStartLock.Unlock()
 
-	currentItemLock.Lock()
-	currentItemID = ""
-	currentItemLock.Unlock()
-
 	SetDownloadProgress(0)
 	SetDownloadSpeed(0)
 }
 
 func CancelAllQueuedItems() {
-	downloadQueueLock.Lock()
-	defer downloadQueueLock.Unlock()
+	queuePersistLock.Lock()
+	defer queuePersistLock.Unlock()
 
+	downloadQueueLock.Lock()
+	var cancelled []DownloadItem
 	for i := range downloadQueue {
 		if downloadQueue[i].Status == StatusQueued {
 			downloadQueue[i].Status = StatusSkipped
 			downloadQueue[i].EndTime = time.Now().Unix()
 			downloadQueue[i].ErrorMessage = "Cancelled"
+			cancelled = append(cancelled, downloadQueue[i])
 		}
 	}
+	downloadQueueLock.Unlock()
+
+	persistQueueItems(cancelled...)
 }
 
 func ResetSessionIfComplete() {
@@ -0,0 +1,220 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// TrackRequest is the provider-independent description of a track to
+// download. Metadata fields come from Spotify and are what ends up in the
+// tags and filename, whatever provider serves the audio.
+type TrackRequest struct {
+	SpotifyID  string `json:"spotify_id,omitempty"`
+	ISRC       string `json:"isrc,omitempty"`
+	ServiceURL string `json:"service_url,omitempty"`
+	// ServiceURLs holds links already resolved through SongLink, keyed by
+	// provider ("tidal", "amazon", "deezer"). A non-nil map means the lookup
+	// was done, so providers treat a missing entry as "not available".
+	ServiceURLs map[string]string `json:"service_urls,omitempty"`
+	TrackName   string            `json:"track_name,omitempty"`
+	ArtistName  string            `json:"artist_name,omitempty"`
+	AlbumName   string            `json:"album_name,omitempty"`
+	AlbumArtist string            `json:"album_artist,omitempty"`
+	ReleaseDate string            `json:"release_date,omitempty"`
+	CoverURL    string            `json:"cover_url,omitempty"`
+	TrackNumber int               `json:"track_number,omitempty"`
+	DiscNumber  int               `json:"disc_number,omitempty"`
+	TotalTracks int               `json:"total_tracks,omitempty"`
+	TotalDiscs  int               `json:"total_discs,omitempty"`
+	Copyright   string            `json:"copyright,omitempty"`
+	Publisher   string            `json:"publisher,omitempty"`
+	DurationMS  int               `json:"duration_ms,omitempty"`
+}
+
+// DownloadOptions controls where and how a provider writes the file.
+type DownloadOptions struct {
+	OutputDir            string `json:"output_dir,omitempty"`
+	Quality              string `json:"quality,omitempty"`
+	FilenameFormat       string `json:"filename_format,omitempty"`
+	PlaylistName         string `json:"playlist_name,omitempty"`
+	PlaylistOwner        string `json:"playlist_owner,omitempty"`
+	IncludeTrackNumber   bool   `json:"include_track_number,omitempty"`
+	Position             int    `json:"position,omitempty"`
+	UseAlbumTrackNumber  bool   `json:"use_album_track_number,omitempty"`
+	EmbedMaxQualityCover bool   `json:"embed_max_quality_cover,omitempty"`
+	AllowFallback        bool   `json:"allow_fallback,omitempty"`
+	ApiURL               string `json:"api_url,omitempty"`
+	// MinQuality is enforced on the written file; see QualityFloor.
+	MinQuality QualityFloor `json:"min_quality,omitempty"`
+	// Verify runs after the quality floor; see Verification.
+	Verify Verification `json:"verify,omitempty"`
+}
+
+// ResolvedTrack identifies a track in one provider's catalog.
+type ResolvedTrack struct {
+	Provider string `json:"provider"`
+	URL      string `json:"url,omitempty"`
+	TrackID  string `json:"track_id,omitempty"`
+	ISRC     string `json:"isrc,omitempty"`
+}
+
+// ErrQualityUnknown is returned by ProbeQuality when a provider has no way to
+// report quality ahead of a download, or its answer names no known quality.
+var ErrQualityUnknown = errors.New("quality unknown until downloaded")
+
+// QualityInfo is the best quality a provider reports for a track. SampleRate
+// is in Hz and is 0 when the provider does not say.
+type QualityInfo struct {
+	Lossless   bool `json:"lossless"`
+	BitDepth   int  `json:"bit_depth,omitempty"`
+	SampleRate int  `json:"sample_rate,omitempty"`
+}
+
+// Provider is a source that can serve full tracks.
+//
+// Resolve maps a track to the provider's catalog, ProbeQuality reports what
+// the provider can deliver for it (ErrQualityUnknown when the provider cannot
+// tell without downloading), FetchStream returns a playable URL and
+// Download writes a tagged file to disk. Download returns the file path,
+// prefixed with "EXISTS:" when the file was already present.
+type Provider interface {
+	Name() string
+	Resolve(ctx context.Context, track TrackRequest) (*ResolvedTrack, error)
+	ProbeQuality(ctx context.Context, resolved *ResolvedTrack) (*QualityInfo, error)
+	FetchStream(ctx context.Context, resolved *ResolvedTrack, quality string) (string, error)
+	Download(ctx context.Context, resolved *ResolvedTrack, track TrackRequest, opts DownloadOptions) (string, error)
+}
+
+var (
+	providers     = make(map[string]Provider)
+	providerNames []string
+	providersLock sync.RWMutex
+)
+
+func init() {
+	RegisterProvider(tidalProvider{})
+	RegisterProvider(qobuzProvider{})
+	RegisterProvider(amazonProvider{})
+}
+
+// RegisterProvider adds p to the registry, replacing any provider with the
+// same name.
+func RegisterProvider(p Provider) {
+	name := strings.ToLower(p.Name())
+
+	providersLock.Lock()
+	defer providersLock.Unlock()
+
+	if _, exists := providers[name]; !exists {
+		providerNames = append(providerNames, name)
+	}
+	providers[name] = p
+}
+
+func GetProvider(name string) (Provider, error) {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+
+	p, ok := providers[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown service: %s", name)
+	}
+	return p, nil
+}
+
+// ProviderNames returns the registered provider names in registration order.
+func ProviderNames() []string {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+
+	names := make([]string, len(providerNames))
+	copy(names, providerNames)
+	return names
+}
+
+// DownloadWithProvider resolves track on the named provider and downloads it.
+func DownloadWithProvider(ctx context.Context, name string, track TrackRequest, opts DownloadOptions) (string, error) {
+	provider, err := GetProvider(name)
+	if err != nil {
+		return "", err
+	}
+	return downloadFromProvider(ctx, provider, track, opts)
+}
+
+func (t TrackRequest) SpotifyURL() string {
+	if t.SpotifyID == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://open.spotify.com/track/%s", t.SpotifyID)
+}
+
+// serviceURLFor returns the link for provider, preferring an explicit
+// ServiceURL that points at host over the SongLink results.
+func (t TrackRequest) serviceURLFor(provider, host string) string {
+	if t.ServiceURL != "" && strings.Contains(strings.ToLower(t.ServiceURL), host) {
+		return t.ServiceURL
+	}
+	return t.ServiceURLs[provider]
+}
+
+func (t TrackRequest) metadata() Metadata {
+	trackNumber := t.TrackNumber
+	if trackNumber == 0 {
+		trackNumber = 1
+	}
+
+	// Spotify metadata puts the Spotify ID in ISRC when the real code is not
+	// known yet, so only tag codes that look like one.
+	isrc := ""
+	if IsValidISRC(t.ISRC) {
+		isrc = t.ISRC
+	}
+
+	return Metadata{
+		Title:       t.TrackName,
+		Artist:      t.ArtistName,
+		Album:       t.AlbumName,
+		AlbumArtist: t.AlbumArtist,
+		Date:        t.ReleaseDate,
+		TrackNumber: trackNumber,
+		TotalTracks: t.TotalTracks,
+		DiscNumber:  t.DiscNumber,
+		TotalDiscs:  t.TotalDiscs,
+		URL:         t.SpotifyURL(),
+		Copyright:   t.Copyright,
+		Publisher:   t.Publisher,
+		Description: "https://github.com/afkarxyz/SpotiFLAC",
+		ISRC:        isrc,
+	}
+}
+
+// embedTrackMetadata downloads the Spotify cover next to path and writes the
+// track's tags and cover into the file.
+func embedTrackMetadata(path string, track TrackRequest, opts DownloadOptions) error {
+	coverPath := ""
+
+	if track.CoverURL != "" {
+		coverPath = path + ".cover.jpg"
+		coverClient := NewCoverClient()
+		if err := coverClient.DownloadCoverToPath(track.CoverURL, coverPath, opts.EmbedMaxQualityCover); err != nil {
+			fmt.Printf("Warning: Failed to download Spotify cover: %v\n", err)
+			coverPath = ""
+		} else {
+			defer os.Remove(coverPath)
+			fmt.Println("Spotify cover downloaded")
+		}
+	}
+
+	return EmbedMetadata(path, track.metadata(), coverPath)
+}
+
+var isrcRegex = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}\d{2}\d{5}$`)
+
+func IsValidISRC(isrc string) bool {
+	return isrcRegex.MatchString(isrc)
+}
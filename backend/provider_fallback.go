@@ -0,0 +1,274 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DefaultProviderOrder is the order auto mode tries providers in when the
+// user has not configured one.
+var DefaultProviderOrder = []string{"tidal", "amazon", "qobuz"}
+
+// ProviderAttempt records how one provider fared during a fallback run.
+type ProviderAttempt struct {
+	Provider string `json:"provider"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FallbackResult is the outcome of DownloadWithFallback.
+type FallbackResult struct {
+	Path     string            `json:"path,omitempty"`
+	Provider string            `json:"provider,omitempty"`
+	Attempts []ProviderAttempt `json:"attempts,omitempty"`
+	Probes   []ProviderProbe   `json:"probes,omitempty"`
+}
+
+// ProviderOrder returns the providers to try, in order. A specific preferred
+// provider goes first and the rest follow in the configured order; "auto" or
+// an empty preference uses the configured order as is. Unknown names are
+// dropped and any registered provider missing from order is appended.
+func ProviderOrder(preferred string, order []string) []string {
+	if len(order) == 0 {
+		order = DefaultProviderOrder
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			return
+		}
+		if _, err := GetProvider(name); err != nil {
+			return
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+
+	if preferred != "auto" {
+		add(preferred)
+	}
+	for _, name := range order {
+		add(name)
+	}
+	for _, name := range ProviderNames() {
+		add(name)
+	}
+	return result
+}
+
+// ProviderQuality maps a quality preference onto the values a provider
+// expects. Provider-native values pass through unchanged; "16" and "24" are
+// accepted as provider-neutral bit depth preferences.
+func ProviderQuality(provider, quality string) string {
+	q := strings.ToUpper(strings.TrimSpace(quality))
+
+	switch strings.ToLower(provider) {
+	case "tidal":
+		switch q {
+		case "24", "7", "27", "HI_RES":
+			return "HI_RES_LOSSLESS"
+		case "16", "6", "":
+			return "LOSSLESS"
+		}
+	case "qobuz":
+		switch q {
+		case "24", "HI_RES", "HI_RES_LOSSLESS":
+			return "7"
+		case "16", "LOSSLESS", "":
+			return "6"
+		}
+	}
+	return quality
+}
+
+// ProbedQuality returns the provider-native quality that asks for everything
+// a probe reported, or "" when the probe gives nothing to go on. Qobuz serves
+// 24-bit up to 96 kHz as "7" and above that only as "27".
+func ProbedQuality(provider string, q *QualityInfo) string {
+	if q == nil || !q.Lossless {
+		return ""
+	}
+
+	switch strings.ToLower(provider) {
+	case "tidal":
+		if q.BitDepth >= 24 {
+			return "HI_RES_LOSSLESS"
+		}
+		return "LOSSLESS"
+	case "qobuz":
+		switch {
+		case q.BitDepth >= 24 && q.SampleRate > 96000:
+			return "27"
+		case q.BitDepth >= 24:
+			return "7"
+		}
+		return "6"
+	}
+	return ""
+}
+
+// resolveServiceLinks looks the track up on SongLink once so every provider
+// in a fallback run can reuse the links. It leaves the track unchanged when
+// there is no Spotify ID or the lookup fails, in which case each provider
+// resolves the track itself.
+func resolveServiceLinks(track TrackRequest) TrackRequest {
+	if track.SpotifyID == "" || track.ServiceURLs != nil {
+		return track
+	}
+
+	urls, err := NewSongLinkClient().GetAllURLsFromSpotify(track.SpotifyID, "US")
+	if err != nil {
+		fmt.Printf("SongLink lookup failed, providers will resolve individually: %v\n", err)
+		return track
+	}
+
+	track.ServiceURLs = map[string]string{}
+	if urls.TidalURL != "" {
+		track.ServiceURLs["tidal"] = urls.TidalURL
+	}
+	if urls.AmazonURL != "" {
+		track.ServiceURLs["amazon"] = urls.AmazonURL
+	}
+	if urls.DeezerURL != "" {
+		track.ServiceURLs["deezer"] = urls.DeezerURL
+	}
+	return track
+}
+
+// DownloadWithFallback tries each provider in order until one downloads the
+// track. Links are resolved through SongLink once up front. Unless policy is
+// ProviderPolicyOrdered (or empty), every provider is probed first and the
+// order is re-ranked by RankProviders; under ProviderPolicyHighestQuality each
+// provider is then asked for the best quality its probe reported rather than
+// the mapped preference. The result lists every provider that
+// was tried and why it failed, and is returned alongside the error when all
+// of them fail.
+func DownloadWithFallback(ctx context.Context, order []string, policy string, track TrackRequest, opts DownloadOptions) (*FallbackResult, error) {
+	track = resolveServiceLinks(track)
+	result := &FallbackResult{}
+
+	if policy != "" && policy != ProviderPolicyOrdered {
+		result.Probes = ProbeProviders(ctx, order, track)
+		order = RankProviders(result.Probes, policy)
+		fmt.Printf("Provider order (%s): %s\n", policy, strings.Join(order, ", "))
+	}
+
+	var lastErr error
+	for _, name := range order {
+		if ctx.Err() != nil {
+			return result, context.Cause(ctx)
+		}
+
+		provider, err := GetProvider(name)
+		if err != nil {
+			result.Attempts = append(result.Attempts, ProviderAttempt{Provider: name, Error: err.Error()})
+			lastErr = err
+			continue
+		}
+
+		fmt.Printf("Trying %s...\n", name)
+
+		providerOpts := opts
+		providerOpts.Quality = ProviderQuality(name, opts.Quality)
+		if policy == ProviderPolicyHighestQuality {
+			for _, probe := range result.Probes {
+				if probe.Provider != name {
+					continue
+				}
+				if quality := ProbedQuality(name, probe.Quality); quality != "" {
+					providerOpts.Quality = quality
+				}
+			}
+		}
+
+		path, err := downloadFromProvider(ctx, provider, track, providerOpts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, context.Cause(ctx)
+			}
+			fmt.Printf("%s failed: %v\n", name, err)
+			result.Attempts = append(result.Attempts, ProviderAttempt{Provider: name, Error: err.Error()})
+			lastErr = fmt.Errorf("%s: %w", name, err)
+			continue
+		}
+
+		result.Attempts = append(result.Attempts, ProviderAttempt{Provider: name, Success: true})
+		result.Provider = name
+		result.Path = path
+		return result, nil
+	}
+
+	if lastErr == nil {
+		return result, fmt.Errorf("no providers to try")
+	}
+	return result, fmt.Errorf("all providers failed, last error: %w", lastErr)
+}
+
+// downloadFromProvider runs one fallback attempt. Files the attempt wrote are
+// removed, with their .part data, when it fails for any reason other than the
+// download being stopped, so the next provider starts clean.
+func downloadFromProvider(ctx context.Context, provider Provider, track TrackRequest, opts DownloadOptions) (string, error) {
+	release, err := providerSlots.acquire(ctx, provider.Name())
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	resolved, err := provider.Resolve(ctx, track)
+	if err != nil {
+		return "", err
+	}
+
+	files := &attemptFiles{}
+	path, err := provider.Download(context.WithValue(ctx, attemptFilesKey{}, files), resolved, track, opts)
+	if err == nil {
+		err = enforceQualityFloor(path, opts.MinQuality)
+	}
+	if err == nil {
+		err = enforceVerification(path, track.DurationMS, opts.Verify)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			files.remove()
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+type attemptFilesKey struct{}
+
+// attemptFiles collects the output paths a fallback attempt downloads to.
+type attemptFiles struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// trackAttemptFile records that path is being written by the current
+// fallback attempt, if there is one.
+func trackAttemptFile(ctx context.Context, path string) {
+	files, ok := ctx.Value(attemptFilesKey{}).(*attemptFiles)
+	if !ok {
+		return
+	}
+	files.mu.Lock()
+	files.paths = append(files.paths, path)
+	files.mu.Unlock()
+}
+
+func (f *attemptFiles) remove() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, path := range f.paths {
+		if err := os.Remove(path); err == nil {
+			fmt.Printf("Removed file of failed attempt: %s\n", path)
+		}
+		removePartFiles(path)
+	}
+}
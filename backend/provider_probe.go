@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Provider selection policies for auto mode.
+const (
+	// ProviderPolicyOrdered tries providers in the configured order without
+	// probing them first.
+	ProviderPolicyOrdered = "ordered"
+	// ProviderPolicyHighestQuality tries the provider reporting the best
+	// quality first.
+	ProviderPolicyHighestQuality = "highest_quality"
+	// ProviderPolicyFastest tries the provider that answered the probe
+	// quickest first.
+	ProviderPolicyFastest = "fastest"
+)
+
+const providerProbeTimeout = 20 * time.Second
+
+// ProviderProbe is one provider's answer to a quality probe. Unknown is set
+// when the provider has the track but cannot report its quality.
+type ProviderProbe struct {
+	Provider  string       `json:"provider"`
+	Quality   *QualityInfo `json:"quality,omitempty"`
+	Unknown   bool         `json:"unknown,omitempty"`
+	LatencyMS int64        `json:"latency_ms"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// ProbeProviders asks every provider in order, in parallel, for the best
+// quality it can deliver for track. Results are returned in the same order.
+func ProbeProviders(ctx context.Context, order []string, track TrackRequest) []ProviderProbe {
+	track = resolveServiceLinks(track)
+
+	ctx, cancel := context.WithTimeout(ctx, providerProbeTimeout)
+	defer cancel()
+
+	probes := make([]ProviderProbe, len(order))
+	var wg sync.WaitGroup
+	for i, name := range order {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			probes[i] = probeProvider(ctx, name, track)
+		}(i, name)
+	}
+	wg.Wait()
+
+	return probes
+}
+
+func probeProvider(ctx context.Context, name string, track TrackRequest) (probe ProviderProbe) {
+	probe.Provider = name
+	start := time.Now()
+	defer func() {
+		probe.LatencyMS = time.Since(start).Milliseconds()
+	}()
+
+	provider, err := GetProvider(name)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+
+	resolved, err := provider.Resolve(ctx, track)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+
+	quality, err := provider.ProbeQuality(ctx, resolved)
+	if errors.Is(err, ErrQualityUnknown) {
+		probe.Unknown = true
+		return probe
+	}
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	probe.Quality = quality
+	return probe
+}
+
+// RankProviders reorders probed providers according to policy. For the
+// quality policy, providers that could not report a quality follow the
+// measured ones; the fastest policy orders every provider that answered by
+// latency alone. Providers whose probe failed keep their relative order at
+// the end so they are still tried as a last resort. Ties keep the original
+// order.
+func RankProviders(probes []ProviderProbe, policy string) []string {
+	ranked := make([]ProviderProbe, len(probes))
+	copy(ranked, probes)
+
+	tier := func(p ProviderProbe) int {
+		switch {
+		case p.Quality != nil:
+			return 0
+		case p.Unknown:
+			if policy == ProviderPolicyFastest {
+				return 0
+			}
+			return 1
+		}
+		return 2
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if tier(a) != tier(b) {
+			return tier(a) < tier(b)
+		}
+		if tier(a) == 2 {
+			return false
+		}
+
+		switch policy {
+		case ProviderPolicyFastest:
+			return a.LatencyMS < b.LatencyMS
+		case ProviderPolicyHighestQuality:
+			if a.Quality == nil || b.Quality == nil {
+				return false
+			}
+			return a.Quality.betterThan(b.Quality)
+		}
+		return false
+	})
+
+	names := make([]string, len(ranked))
+	for i, probe := range ranked {
+		names[i] = probe.Provider
+	}
+	return names
+}
+
+func (q *QualityInfo) betterThan(other *QualityInfo) bool {
+	if q.Lossless != other.Lossless {
+		return q.Lossless
+	}
+	if q.BitDepth != other.BitDepth {
+		return q.BitDepth > other.BitDepth
+	}
+	return q.SampleRate > other.SampleRate
+}
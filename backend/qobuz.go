@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -70,10 +71,8 @@ type QobuzStreamResponse struct {
 
 func NewQobuzDownloader() *QobuzDownloader {
 	return &QobuzDownloader{
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-		},
-		appID: "798273057",
+		client: newAPIClient(60 * time.Second),
+		appID:  "798273057",
 	}
 }
 
@@ -308,40 +307,12 @@ func (q *QobuzDownloader) GetDownloadURL(trackID int64, quality string, allowFal
 	return "", fmt.Errorf("all APIs and fallbacks failed. Last error: %v", err)
 }
 
-func (q *QobuzDownloader) DownloadFile(url, filepath string) error {
+func (q *QobuzDownloader) DownloadFile(ctx context.Context, url, filepath string) error {
 	fmt.Println("Starting file download...")
 
-	downloadClient := &http.Client{
-		Timeout: 5 * time.Minute,
-	}
-
-	resp, err := downloadClient.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("download failed with status %d", resp.StatusCode)
-	}
-
-	fmt.Printf("Creating file: %s\n", filepath)
-	out, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer out.Close()
-
 	fmt.Println("Downloading...")
-
-	pw := NewProgressWriter(out)
-	_, err = io.Copy(pw, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
-	}
-
-	fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(pw.GetTotal())/(1024*1024))
-	return nil
+	_, err := DownloadResumable(ctx, newDownloadClient(), url, filepath)
+	return err
 }
 
 func (q *QobuzDownloader) DownloadCoverArt(coverURL, filepath string) error {
@@ -423,35 +394,31 @@ func buildQobuzFilename(title, artist, album, albumArtist, releaseDate string, t
 	return filename + ".flac"
 }
 
-func (q *QobuzDownloader) DownloadByISRC(deezerISRC, outputDir, quality, filenameFormat string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate string, useAlbumTrackNumber bool, spotifyCoverURL string, embedMaxQualityCover bool, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string, allowFallback bool) (string, error) {
+func (q *QobuzDownloader) DownloadByISRC(ctx context.Context, deezerISRC string, track TrackRequest, opts DownloadOptions) (string, error) {
 	fmt.Printf("Fetching track info for ISRC: %s\n", deezerISRC)
 
-	if outputDir != "." {
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
+	if opts.OutputDir != "." {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
 			return "", fmt.Errorf("failed to create output directory: %w", err)
 		}
 	}
 
-	track, err := q.SearchByISRC(deezerISRC)
+	qobuzTrack, err := q.SearchByISRC(deezerISRC)
 	if err != nil {
 		return "", err
 	}
 
-	artists := spotifyArtistName
-	trackTitle := spotifyTrackName
-	albumTitle := spotifyAlbumName
-
-	fmt.Printf("Found track: %s - %s\n", artists, trackTitle)
-	fmt.Printf("Album: %s\n", albumTitle)
+	fmt.Printf("Found track: %s - %s\n", track.ArtistName, track.TrackName)
+	fmt.Printf("Album: %s\n", track.AlbumName)
 
 	qualityInfo := "Standard"
-	if track.Hires {
-		qualityInfo = fmt.Sprintf("Hi-Res (%d-bit / %.1f kHz)", track.MaximumBitDepth, track.MaximumSamplingRate)
+	if qobuzTrack.Hires {
+		qualityInfo = fmt.Sprintf("Hi-Res (%d-bit / %.1f kHz)", qobuzTrack.MaximumBitDepth, qobuzTrack.MaximumSamplingRate)
 	}
 	fmt.Printf("Quality: %s\n", qualityInfo)
 
 	fmt.Println("Getting download URL...")
-	downloadURL, err := q.GetDownloadURL(track.ID, quality, allowFallback)
+	downloadURL, err := q.GetDownloadURL(qobuzTrack.ID, opts.Quality, opts.AllowFallback)
 	if err != nil {
 		return "", fmt.Errorf("failed to get download URL: %w", err)
 	}
@@ -466,13 +433,13 @@ func (q *QobuzDownloader) DownloadByISRC(deezerISRC, outputDir, quality, filenam
 	}
 	fmt.Printf("Download URL obtained: %s\n", urlPreview)
 
-	safeArtist := sanitizeFilename(artists)
-	safeTitle := sanitizeFilename(trackTitle)
-	safeAlbum := sanitizeFilename(albumTitle)
-	safeAlbumArtist := sanitizeFilename(spotifyAlbumArtist)
+	safeArtist := sanitizeFilename(track.ArtistName)
+	safeTitle := sanitizeFilename(track.TrackName)
+	safeAlbum := sanitizeFilename(track.AlbumName)
+	safeAlbumArtist := sanitizeFilename(track.AlbumArtist)
 
-	filename := buildQobuzFilename(safeTitle, safeArtist, safeAlbum, safeAlbumArtist, spotifyReleaseDate, spotifyTrackNumber, spotifyDiscNumber, filenameFormat, includeTrackNumber, position, useAlbumTrackNumber)
-	filepath := filepath.Join(outputDir, filename)
+	filename := buildQobuzFilename(safeTitle, safeArtist, safeAlbum, safeAlbumArtist, track.ReleaseDate, track.TrackNumber, track.DiscNumber, opts.FilenameFormat, opts.IncludeTrackNumber, opts.Position, opts.UseAlbumTrackNumber)
+	filepath := filepath.Join(opts.OutputDir, filename)
 
 	if fileInfo, err := os.Stat(filepath); err == nil && fileInfo.Size() > 0 {
 		fmt.Printf("File already exists: %s (%.2f MB)\n", filepath, float64(fileInfo.Size())/(1024*1024))
@@ -480,53 +447,83 @@ func (q *QobuzDownloader) DownloadByISRC(deezerISRC, outputDir, quality, filenam
 	}
 
 	fmt.Printf("Downloading FLAC file to: %s\n", filepath)
-	if err := q.DownloadFile(downloadURL, filepath); err != nil {
+	if err := q.DownloadFile(ctx, downloadURL, filepath); err != nil {
 		return "", fmt.Errorf("failed to download file: %w", err)
 	}
 
 	fmt.Printf("Downloaded: %s\n", filepath)
 
-	coverPath := ""
+	fmt.Println("Embedding metadata and cover art...")
 
-	if spotifyCoverURL != "" {
-		coverPath = filepath + ".cover.jpg"
-		coverClient := NewCoverClient()
-		if err := coverClient.DownloadCoverToPath(spotifyCoverURL, coverPath, embedMaxQualityCover); err != nil {
-			fmt.Printf("Warning: Failed to download Spotify cover: %v\n", err)
-			coverPath = ""
-		} else {
-			defer os.Remove(coverPath)
-			fmt.Println("Spotify cover downloaded")
+	if !IsValidISRC(track.ISRC) && IsValidISRC(deezerISRC) {
+		track.ISRC = deezerISRC
+	}
+	if err := embedTrackMetadata(filepath, track, opts); err != nil {
+		return "", fmt.Errorf("failed to embed metadata: %w", err)
+	}
+
+	fmt.Println("Metadata embedded successfully!")
+	return filepath, nil
+}
+
+type qobuzProvider struct{}
+
+func (qobuzProvider) Name() string { return "qobuz" }
+
+// Resolve finds the track's ISRC, going through Deezer when the request does
+// not carry a valid one. Qobuz is searched by ISRC at download time.
+func (qobuzProvider) Resolve(ctx context.Context, track TrackRequest) (*ResolvedTrack, error) {
+	isrc := track.ISRC
+	if !IsValidISRC(isrc) {
+		isrc = ""
+	}
+
+	if isrc == "" && (track.SpotifyID != "" || track.ServiceURLs["deezer"] != "") {
+		var err error
+		deezerURL := track.ServiceURLs["deezer"]
+		if deezerURL == "" {
+			deezerURL, err = NewSongLinkClient().GetDeezerURLFromSpotify(track.SpotifyID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get Deezer URL: %w", err)
+			}
+		}
+		isrc, err = GetDeezerISRC(deezerURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ISRC from Deezer: %w", err)
 		}
 	}
+	if isrc == "" {
+		return nil, fmt.Errorf("ISRC is required for Qobuz (could not fetch from Deezer)")
+	}
 
-	fmt.Println("Embedding metadata and cover art...")
+	return &ResolvedTrack{Provider: "qobuz", ISRC: isrc}, nil
+}
 
-	trackNumberToEmbed := spotifyTrackNumber
-	if trackNumberToEmbed == 0 {
-		trackNumberToEmbed = 1
+func (qobuzProvider) ProbeQuality(ctx context.Context, resolved *ResolvedTrack) (*QualityInfo, error) {
+	track, err := NewQobuzDownloader().SearchByISRC(resolved.ISRC)
+	if err != nil {
+		return nil, err
 	}
 
-	metadata := Metadata{
-		Title:       trackTitle,
-		Artist:      artists,
-		Album:       albumTitle,
-		AlbumArtist: spotifyAlbumArtist,
-		Date:        spotifyReleaseDate,
-		TrackNumber: trackNumberToEmbed,
-		TotalTracks: spotifyTotalTracks,
-		DiscNumber:  spotifyDiscNumber,
-		TotalDiscs:  spotifyTotalDiscs,
-		URL:         spotifyURL,
-		Copyright:   spotifyCopyright,
-		Publisher:   spotifyPublisher,
-		Description: "https://github.com/afkarxyz/SpotiFLAC",
+	info := &QualityInfo{Lossless: true, BitDepth: 16, SampleRate: 44100}
+	if track.MaximumBitDepth > 0 {
+		info.BitDepth = track.MaximumBitDepth
+	}
+	if track.MaximumSamplingRate > 0 {
+		info.SampleRate = int(track.MaximumSamplingRate * 1000)
 	}
+	return info, nil
+}
 
-	if err := EmbedMetadata(filepath, metadata, coverPath); err != nil {
-		return "", fmt.Errorf("failed to embed metadata: %w", err)
+func (qobuzProvider) FetchStream(ctx context.Context, resolved *ResolvedTrack, quality string) (string, error) {
+	downloader := NewQobuzDownloader()
+	track, err := downloader.SearchByISRC(resolved.ISRC)
+	if err != nil {
+		return "", err
 	}
+	return downloader.GetDownloadURL(track.ID, quality, false)
+}
 
-	fmt.Println("Metadata embedded successfully!")
-	return filepath, nil
+func (qobuzProvider) Download(ctx context.Context, resolved *ResolvedTrack, track TrackRequest, opts DownloadOptions) (string, error) {
+	return NewQobuzDownloader().DownloadByISRC(ctx, resolved.ISRC, track, opts)
 }
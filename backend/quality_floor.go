@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// What happens when a download does not reach its QualityFloor.
+const (
+	// QualityFloorFail removes the file and fails the download. In auto mode
+	// the next provider is tried.
+	QualityFloorFail = "fail"
+	// QualityFloorFlag keeps the file and marks the item as downgraded.
+	QualityFloorFlag = "flag"
+)
+
+// QualityFloor is the minimum quality a download has to reach, checked
+// against the STREAMINFO of the file that was actually written. Zero fields
+// are not checked.
+type QualityFloor struct {
+	MinBitDepth   int    `json:"min_bit_depth,omitempty"`
+	MinSampleRate int    `json:"min_sample_rate,omitempty"`
+	OnViolation   string `json:"on_violation,omitempty"`
+}
+
+func (f QualityFloor) IsZero() bool {
+	return f.MinBitDepth <= 0 && f.MinSampleRate <= 0
+}
+
+func (f QualityFloor) flagOnly() bool {
+	return f.OnViolation == QualityFloorFlag
+}
+
+// QualityFloorError is returned when a downloaded file is below the floor
+// and the floor is set to fail.
+type QualityFloorError struct {
+	Shortfall string
+}
+
+func (e *QualityFloorError) Error() string {
+	return "below minimum quality: " + e.Shortfall
+}
+
+// CheckQualityFloor reads the file's STREAMINFO and describes how it falls
+// short of f. It returns an empty string when the file meets the floor.
+func CheckQualityFloor(path string, f QualityFloor) (string, error) {
+	if f.IsZero() {
+		return "", nil
+	}
+
+	meta, err := GetTrackMetadata(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stream info: %w", err)
+	}
+
+	var shortfalls []string
+	if f.MinBitDepth > 0 && int(meta.BitsPerSample) < f.MinBitDepth {
+		shortfalls = append(shortfalls, fmt.Sprintf("%d-bit < %d-bit", meta.BitsPerSample, f.MinBitDepth))
+	}
+	if f.MinSampleRate > 0 && int(meta.SampleRate) < f.MinSampleRate {
+		shortfalls = append(shortfalls, fmt.Sprintf("%.1fkHz < %.1fkHz", float64(meta.SampleRate)/1000, float64(f.MinSampleRate)/1000))
+	}
+	return strings.Join(shortfalls, ", "), nil
+}
+
+// enforceQualityFloor applies a fail-mode floor to a freshly downloaded file,
+// removing it when it falls short. A file that cannot be read as FLAC counts
+// as falling short. Flag-mode floors are left to the caller.
+func enforceQualityFloor(path string, f QualityFloor) error {
+	if f.IsZero() || f.flagOnly() || path == "" || strings.HasPrefix(path, "EXISTS:") {
+		return nil
+	}
+
+	shortfall, err := CheckQualityFloor(path, f)
+	if err != nil {
+		shortfall = err.Error()
+	}
+	if shortfall == "" {
+		return nil
+	}
+
+	fmt.Printf("Download is below minimum quality (%s), removing %s\n", shortfall, path)
+	os.Remove(path)
+	return &QualityFloorError{Shortfall: shortfall}
+}
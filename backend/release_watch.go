@@ -0,0 +1,561 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	watchedArtistsBucket = "WatchedArtists"
+	newReleasesBucket    = "NewReleases"
+	releaseWatchBucket   = "ReleaseWatch"
+	releaseWatchKey      = "config"
+
+	defaultReleaseWatchHours = 12
+	// releaseCheckPause spaces out artist checks so a long watch list does
+	// not hammer the Spotify API.
+	releaseCheckPause = 2 * time.Second
+)
+
+var spotifyIDRegex = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+
+// ReleaseWatchConfig controls the release watcher. Types limits which release
+// types ("album", "single", "compilation") are reported; empty means all.
+// With AutoDownload set, new releases are queued with Options, the download
+// request template, kept as JSON since its type lives in the app.
+type ReleaseWatchConfig struct {
+	Enabled       bool            `json:"enabled"`
+	IntervalHours int             `json:"interval_hours"`
+	Types         []string        `json:"types,omitempty"`
+	AutoDownload  bool            `json:"auto_download"`
+	Options       json.RawMessage `json:"options,omitempty"`
+}
+
+func DefaultReleaseWatchConfig() ReleaseWatchConfig {
+	return ReleaseWatchConfig{
+		Enabled:       true,
+		IntervalHours: defaultReleaseWatchHours,
+	}
+}
+
+func (c ReleaseWatchConfig) interval() time.Duration {
+	hours := c.IntervalHours
+	if hours <= 0 {
+		hours = defaultReleaseWatchHours
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func (c ReleaseWatchConfig) wants(releaseType string) bool {
+	if len(c.Types) == 0 {
+		return true
+	}
+	for _, t := range c.Types {
+		if strings.EqualFold(t, releaseType) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchedArtist is an artist on the watch list. Releases holds the release
+// IDs seen at the last check, the snapshot the next check is diffed against.
+type WatchedArtist struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Image       string   `json:"image,omitempty"`
+	Releases    []string `json:"releases"`
+	AddedAt     int64    `json:"added_at"`
+	LastChecked int64    `json:"last_checked,omitempty"`
+	LastError   string   `json:"last_error,omitempty"`
+}
+
+// NewRelease is a release that appeared since the previous check. It stays
+// listed until dismissed. CollectionID is set when it was queued.
+type NewRelease struct {
+	ArtistID     string                   `json:"artist_id"`
+	ArtistName   string                   `json:"artist_name"`
+	Release      DiscographyAlbumMetadata `json:"release"`
+	FoundAt      int64                    `json:"found_at"`
+	CollectionID string                   `json:"collection_id,omitempty"`
+	QueueError   string                   `json:"queue_error,omitempty"`
+}
+
+func GetReleaseWatchConfig() ReleaseWatchConfig {
+	config := DefaultReleaseWatchConfig()
+	if historyDB == nil {
+		return config
+	}
+	_ = historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(releaseWatchBucket))
+		if b == nil {
+			return nil
+		}
+		if data := b.Get([]byte(releaseWatchKey)); data != nil {
+			return json.Unmarshal(data, &config)
+		}
+		return nil
+	})
+	return config
+}
+
+func SaveReleaseWatchConfig(config ReleaseWatchConfig) error {
+	if historyDB == nil {
+		return fmt.Errorf("history database not initialized")
+	}
+	for i, t := range config.Types {
+		config.Types[i] = strings.ToLower(strings.TrimSpace(t))
+	}
+
+	buf, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(releaseWatchBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(releaseWatchKey), buf)
+	})
+}
+
+func GetWatchedArtists() ([]WatchedArtist, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history database not initialized")
+	}
+
+	var artists []WatchedArtist
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(watchedArtistsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var artist WatchedArtist
+			if err := json.Unmarshal(v, &artist); err == nil {
+				artists = append(artists, artist)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(artists, func(i, j int) bool {
+		return strings.ToLower(artists[i].Name) < strings.ToLower(artists[j].Name)
+	})
+	return artists, nil
+}
+
+func putWatchedArtist(artist *WatchedArtist) error {
+	buf, err := json.Marshal(artist)
+	if err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(watchedArtistsBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(artist.ID), buf)
+	})
+}
+
+// updateWatchedArtist saves artist only if it is still on the watch list,
+// checked inside the same transaction, and reports whether it was.
+func updateWatchedArtist(artist *WatchedArtist) (bool, error) {
+	buf, err := json.Marshal(artist)
+	if err != nil {
+		return false, err
+	}
+	watched := false
+	err = historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(watchedArtistsBucket))
+		if b == nil || b.Get([]byte(artist.ID)) == nil {
+			return nil
+		}
+		watched = true
+		return b.Put([]byte(artist.ID), buf)
+	})
+	return watched && err == nil, err
+}
+
+func UnwatchArtist(id string) error {
+	if historyDB == nil {
+		return fmt.Errorf("history database not initialized")
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(watchedArtistsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// GetNewReleases returns the undismissed new releases, newest first.
+func GetNewReleases() ([]NewRelease, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history database not initialized")
+	}
+
+	var releases []NewRelease
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(newReleasesBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var release NewRelease
+			if err := json.Unmarshal(v, &release); err == nil {
+				releases = append(releases, release)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		if releases[i].Release.ReleaseDate != releases[j].Release.ReleaseDate {
+			return releases[i].Release.ReleaseDate > releases[j].Release.ReleaseDate
+		}
+		return releases[i].FoundAt > releases[j].FoundAt
+	})
+	return releases, nil
+}
+
+// DismissNewReleases removes the given releases from the new list, or all of
+// them when ids is empty.
+func DismissNewReleases(ids []string) error {
+	if historyDB == nil {
+		return fmt.Errorf("history database not initialized")
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		if len(ids) == 0 {
+			if tx.Bucket([]byte(newReleasesBucket)) == nil {
+				return nil
+			}
+			return tx.DeleteBucket([]byte(newReleasesBucket))
+		}
+		b := tx.Bucket([]byte(newReleasesBucket))
+		if b == nil {
+			return nil
+		}
+		for _, id := range ids {
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveNewReleases stores releases, replacing earlier entries for the same
+// release IDs.
+func SaveNewReleases(releases []NewRelease) error {
+	if historyDB == nil || len(releases) == 0 {
+		return nil
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(newReleasesBucket))
+		if err != nil {
+			return err
+		}
+		for _, release := range releases {
+			buf, err := json.Marshal(release)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(release.Release.ID), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parseArtistID accepts an artist URL, a spotify:artist URI or a bare ID.
+func parseArtistID(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if spotifyIDRegex.MatchString(input) {
+		return input, nil
+	}
+	parsed, err := parseSpotifyURI(input)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Type != "artist" && parsed.Type != "artist_discography" {
+		return "", fmt.Errorf("not an artist: %s", input)
+	}
+	return parsed.ID, nil
+}
+
+// fetchReleases lists an artist's releases without fetching their tracks.
+func fetchReleases(ctx context.Context, artistID string) (*apiArtistResponse, []DiscographyAlbumMetadata, error) {
+	client := NewSpotifyMetadataClient()
+	raw, err := client.fetchArtistDiscography(ctx, spotifyURI{Type: "artist_discography", ID: artistID, DiscographyGroup: "all"})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	releases := make([]DiscographyAlbumMetadata, 0, len(raw.Discography.All))
+	for _, alb := range raw.Discography.All {
+		releases = append(releases, DiscographyAlbumMetadata{
+			ID:          alb.ID,
+			Name:        alb.Name,
+			AlbumType:   strings.ToLower(alb.Type),
+			ReleaseDate: alb.Date,
+			TotalTracks: alb.TotalTracks,
+			Artists:     raw.Name,
+			Images:      alb.Cover,
+			ExternalURL: fmt.Sprintf("https://open.spotify.com/album/%s", alb.ID),
+		})
+	}
+	return raw, releases, nil
+}
+
+// WatchArtist adds an artist to the watch list. Its current discography is
+// the baseline, so only releases that appear after this are reported.
+func WatchArtist(ctx context.Context, input string) (*WatchedArtist, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history database not initialized")
+	}
+	id, err := parseArtistID(input)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, releases, err := fetchReleases(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discography: %w", err)
+	}
+
+	now := time.Now().Unix()
+	artist := &WatchedArtist{
+		ID:          id,
+		Name:        raw.Name,
+		Image:       raw.Avatar,
+		AddedAt:     now,
+		LastChecked: now,
+	}
+	for _, release := range releases {
+		artist.Releases = append(artist.Releases, release.ID)
+	}
+	if err := putWatchedArtist(artist); err != nil {
+		return nil, err
+	}
+	return artist, nil
+}
+
+// checkArtist diffs an artist's discography against its snapshot and
+// returns the releases that are new and of a wanted type. The snapshot is
+// replaced either way. An artist unwatched while it was being checked stays
+// gone and reports nothing.
+func checkArtist(ctx context.Context, artist *WatchedArtist, config ReleaseWatchConfig) ([]NewRelease, error) {
+	now := time.Now().Unix()
+	artist.LastChecked = now
+
+	raw, releases, err := fetchReleases(ctx, artist.ID)
+	if err != nil {
+		artist.LastError = err.Error()
+		if _, putErr := updateWatchedArtist(artist); putErr != nil {
+			fmt.Printf("Failed to save watched artist %s: %v\n", artist.ID, putErr)
+		}
+		return nil, err
+	}
+	// An empty answer is more likely a failed query than a wiped catalog;
+	// keep the old snapshot so the next check does not report everything.
+	if len(releases) == 0 && len(artist.Releases) > 0 {
+		artist.LastError = "no releases returned"
+		_, err := updateWatchedArtist(artist)
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(artist.Releases))
+	for _, id := range artist.Releases {
+		known[id] = true
+	}
+
+	var found []NewRelease
+	snapshot := make([]string, 0, len(releases))
+	for _, release := range releases {
+		snapshot = append(snapshot, release.ID)
+		if known[release.ID] || !config.wants(release.AlbumType) {
+			continue
+		}
+		found = append(found, NewRelease{
+			ArtistID:   artist.ID,
+			ArtistName: raw.Name,
+			Release:    release,
+			FoundAt:    now,
+		})
+	}
+
+	artist.Name = raw.Name
+	artist.Image = raw.Avatar
+	artist.Releases = snapshot
+	artist.LastError = ""
+	watched, err := updateWatchedArtist(artist)
+	if !watched {
+		return nil, err
+	}
+	return found, nil
+}
+
+// ReleaseWatcher checks the watch list in the background. Each artist is
+// checked once its last check is older than the configured interval.
+// onNew gets the new releases of every check that found some, with the
+// check's context.
+type ReleaseWatcher struct {
+	onNew func(context.Context, []NewRelease)
+
+	checkMu sync.Mutex
+	wake    chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewReleaseWatcher(onNew func(context.Context, []NewRelease)) *ReleaseWatcher {
+	return &ReleaseWatcher{
+		onNew: onNew,
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+func (w *ReleaseWatcher) Start() {
+	if w.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	go w.loop(ctx)
+}
+
+func (w *ReleaseWatcher) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+	w.cancel = nil
+}
+
+// Reschedule wakes the watcher so a changed interval or watch list takes
+// effect before the current wait is over.
+func (w *ReleaseWatcher) Reschedule() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *ReleaseWatcher) loop(ctx context.Context) {
+	defer close(w.done)
+
+	for {
+		timer := time.NewTimer(w.nextCheck())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-w.wake:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		if _, err := w.check(ctx, false); err != nil && ctx.Err() == nil {
+			fmt.Printf("Release check failed: %v\n", err)
+		}
+	}
+}
+
+// nextCheck is the wait until the next artist is due.
+func (w *ReleaseWatcher) nextCheck() time.Duration {
+	config := GetReleaseWatchConfig()
+	interval := config.interval()
+	if !config.Enabled {
+		return interval
+	}
+
+	artists, err := GetWatchedArtists()
+	if err != nil || len(artists) == 0 {
+		return interval
+	}
+
+	next := interval
+	for _, artist := range artists {
+		due := time.Until(time.Unix(artist.LastChecked, 0).Add(interval))
+		if due < next {
+			next = due
+		}
+	}
+	return max(next, time.Minute)
+}
+
+// CheckNow checks every watched artist right away, whatever the interval.
+func (w *ReleaseWatcher) CheckNow(ctx context.Context) ([]NewRelease, error) {
+	return w.check(ctx, true)
+}
+
+func (w *ReleaseWatcher) check(ctx context.Context, all bool) ([]NewRelease, error) {
+	w.checkMu.Lock()
+	defer w.checkMu.Unlock()
+
+	config := GetReleaseWatchConfig()
+	if !config.Enabled && !all {
+		return nil, nil
+	}
+	artists, err := GetWatchedArtists()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []NewRelease
+	checked := 0
+artists:
+	for i := range artists {
+		artist := &artists[i]
+		if !all && time.Since(time.Unix(artist.LastChecked, 0)) < config.interval() {
+			continue
+		}
+		if checked > 0 {
+			select {
+			case <-ctx.Done():
+				break artists
+			case <-time.After(releaseCheckPause):
+			}
+		}
+		checked++
+
+		releases, err := checkArtist(ctx, artist, config)
+		if err != nil {
+			fmt.Printf("Failed to check releases of %s: %v\n", artist.Name, err)
+			continue
+		}
+		found = append(found, releases...)
+	}
+
+	if len(found) > 0 {
+		if err := SaveNewReleases(found); err != nil {
+			fmt.Printf("Failed to save new releases: %v\n", err)
+		}
+		if w.onNew != nil {
+			w.onNew(ctx, found)
+		}
+	}
+	return found, ctx.Err()
+}
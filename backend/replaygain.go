@@ -0,0 +1,196 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-flac/flacvorbis"
+	"github.com/go-flac/go-flac"
+)
+
+// replayGainReference is the ReplayGain 2.0 target loudness.
+const replayGainReference = -18.0
+
+// ReplayGain holds the values written to the REPLAYGAIN_* Vorbis comments.
+// Gains are in dB and peaks are linear. Album values are left out when
+// HasAlbum is false.
+type ReplayGain struct {
+	TrackGain float64 `json:"track_gain"`
+	TrackPeak float64 `json:"track_peak"`
+	AlbumGain float64 `json:"album_gain,omitempty"`
+	AlbumPeak float64 `json:"album_peak,omitempty"`
+	HasAlbum  bool    `json:"has_album,omitempty"`
+}
+
+func (rg *ReplayGain) addComments(cmt *flacvorbis.MetaDataBlockVorbisComment) {
+	_ = cmt.Add("REPLAYGAIN_TRACK_GAIN", fmt.Sprintf("%.2f dB", rg.TrackGain))
+	_ = cmt.Add("REPLAYGAIN_TRACK_PEAK", fmt.Sprintf("%.6f", rg.TrackPeak))
+	if rg.HasAlbum {
+		_ = cmt.Add("REPLAYGAIN_ALBUM_GAIN", fmt.Sprintf("%.2f dB", rg.AlbumGain))
+		_ = cmt.Add("REPLAYGAIN_ALBUM_PEAK", fmt.Sprintf("%.6f", rg.AlbumPeak))
+	}
+}
+
+// EmbedReplayGainOnly replaces the REPLAYGAIN_* comments of an existing file
+// and keeps every other tag.
+func EmbedReplayGainOnly(filepath string, rg ReplayGain) error {
+	defer lockTagWrites(filepath)()
+
+	f, err := flac.ParseFile(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to parse FLAC file: %w", err)
+	}
+
+	var cmtIdx = -1
+	var existingCmt *flacvorbis.MetaDataBlockVorbisComment
+	for idx, block := range f.Meta {
+		if block.Type == flac.VorbisComment {
+			cmtIdx = idx
+			existingCmt, err = flacvorbis.ParseFromMetaDataBlock(*block)
+			if err != nil {
+				existingCmt = nil
+			}
+			break
+		}
+	}
+
+	cmt := flacvorbis.New()
+
+	if existingCmt != nil {
+		cmt.Vendor = existingCmt.Vendor
+		for _, comment := range existingCmt.Comments {
+			parts := strings.SplitN(comment, "=", 2)
+			if len(parts) == 2 && !strings.HasPrefix(strings.ToUpper(parts[0]), "REPLAYGAIN_") {
+				_ = cmt.Add(parts[0], parts[1])
+			}
+		}
+	}
+
+	rg.addComments(cmt)
+
+	cmtBlock := cmt.Marshal()
+	if cmtIdx < 0 {
+		f.Meta = append(f.Meta, &cmtBlock)
+	} else {
+		f.Meta[cmtIdx] = &cmtBlock
+	}
+
+	if err := f.Save(filepath); err != nil {
+		return fmt.Errorf("failed to save FLAC file: %w", err)
+	}
+
+	return nil
+}
+
+// ReplayGainResult is one file's entry in an ApplyReplayGain report.
+type ReplayGainResult struct {
+	FilePath   string          `json:"file_path"`
+	Loudness   *LoudnessResult `json:"loudness,omitempty"`
+	ReplayGain *ReplayGain     `json:"replay_gain,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// ApplyReplayGain measures every file, treats them together as one album and
+// writes track and album ReplayGain tags. Files that fail to measure are
+// reported and left out of the album gain.
+func ApplyReplayGain(paths []string) []ReplayGainResult {
+	results := make([]ReplayGainResult, len(paths))
+	var measured []*LoudnessResult
+
+	for i, path := range paths {
+		results[i].FilePath = path
+		loudness, err := MeasureLoudness(path)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Loudness = loudness
+		measured = append(measured, loudness)
+	}
+
+	albumLUFS, albumPeak := AlbumLoudness(measured)
+
+	for i := range results {
+		loudness := results[i].Loudness
+		if loudness == nil {
+			continue
+		}
+
+		rg := ReplayGain{
+			TrackGain: replayGainReference - loudness.IntegratedLUFS,
+			TrackPeak: loudness.TruePeak,
+			AlbumGain: replayGainReference - albumLUFS,
+			AlbumPeak: albumPeak,
+			HasAlbum:  len(measured) > 1,
+		}
+		results[i].ReplayGain = &rg
+
+		if err := EmbedReplayGainOnly(results[i].FilePath, rg); err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+
+	return results
+}
+
+// ApplyAlbumReplayGain runs ApplyReplayGain over the FLAC files directly in
+// dir, in name order.
+func ApplyAlbumReplayGain(dir string) ([]ReplayGainResult, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".flac") {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no FLAC files found in %s", dir)
+	}
+
+	return ApplyReplayGain(paths), nil
+}
+
+// ApplyReplayGainToItems tags the files of finished queue items, grouping
+// them by folder so each folder gets its own album gain. Folders where
+// nothing new was downloaded are left alone.
+func ApplyReplayGainToItems(itemIDs []string) {
+	folders := make(map[string][]string)
+	changed := make(map[string]bool)
+
+	for _, id := range itemIDs {
+		item, ok := GetDownloadItem(id)
+		if !ok || item.FilePath == "" || !strings.HasSuffix(strings.ToLower(item.FilePath), ".flac") {
+			continue
+		}
+		if item.Status != StatusCompleted && item.Status != StatusSkipped {
+			continue
+		}
+
+		dir := filepath.Dir(item.FilePath)
+		folders[dir] = append(folders[dir], item.FilePath)
+		if item.Status == StatusCompleted {
+			changed[dir] = true
+		}
+	}
+
+	for dir, paths := range folders {
+		if !changed[dir] {
+			continue
+		}
+		sort.Strings(paths)
+
+		fmt.Printf("Applying ReplayGain to %d files in %s\n", len(paths), dir)
+		for _, result := range ApplyReplayGain(paths) {
+			if result.Error != "" {
+				fmt.Printf("ReplayGain failed for %s: %s\n", result.FilePath, result.Error)
+			}
+		}
+	}
+}
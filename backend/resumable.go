@@ -0,0 +1,267 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	partSuffix          = ".part"
+	partStateSuffix     = ".part.json"
+	resumableMaxRetries = 3
+)
+
+// partState is the sidecar written next to a .part file. It records enough
+// about the upstream response to decide whether a later Range request can
+// continue where the previous attempt stopped.
+type partState struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Offset       int64  `json:"offset"`
+	TotalSize    int64  `json:"total_size,omitempty"`
+	UpdatedAt    int64  `json:"updated_at"`
+}
+
+func readPartState(path string) (*partState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state partState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func writePartState(path string, state *partState) error {
+	state.UpdatedAt = time.Now().Unix()
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func removePartFiles(outputPath string) {
+	os.Remove(outputPath + partSuffix)
+	os.Remove(outputPath + partStateSuffix)
+}
+
+// DownloadResumable downloads url to outputPath through a .part file. An
+// interrupted transfer is continued with a Range request on the next attempt
+// (within this call or a later one), and the .part file is only renamed to
+// outputPath once the full body has been received. Partial data is kept when
+// the download fails or is paused and removed when it is cancelled.
+func DownloadResumable(ctx context.Context, client *http.Client, url, outputPath string) (int64, error) {
+	trackAttemptFile(ctx, outputPath)
+
+	var lastErr error
+	for attempt := 0; attempt < resumableMaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := time.Duration(attempt) * 2 * time.Second
+			fmt.Printf("\nRetrying download in %v (attempt %d/%d): %v\n", wait, attempt+1, resumableMaxRetries, lastErr)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+			}
+		}
+
+		if ctx.Err() != nil {
+			lastErr = ctx.Err()
+			break
+		}
+
+		size, err := downloadPartOnce(ctx, client, url, outputPath)
+		if err == nil {
+			return size, nil
+		}
+		lastErr = err
+
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode < 500 && statusErr.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+	}
+
+	if context.Cause(ctx) == ErrDownloadCancelled {
+		removePartFiles(outputPath)
+	}
+	return 0, lastErr
+}
+
+type httpStatusError struct {
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("download failed with status %d", e.StatusCode)
+}
+
+func downloadPartOnce(ctx context.Context, client *http.Client, url, outputPath string) (int64, error) {
+	partPath := outputPath + partSuffix
+	statePath := outputPath + partStateSuffix
+
+	var offset int64
+	state, err := readPartState(statePath)
+	if err == nil {
+		if info, statErr := os.Stat(partPath); statErr == nil {
+			offset = info.Size()
+		}
+		// Without a validator we can only trust the same URL to still serve
+		// the same bytes.
+		if state.ETag == "" && state.LastModified == "" && state.URL != url {
+			offset = 0
+		}
+	} else {
+		state = &partState{}
+	}
+
+	if offset > 0 && state.TotalSize > 0 && offset == state.TotalSize {
+		return finishPart(partPath, statePath, outputPath, offset)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if state.ETag != "" {
+			req.Header.Set("If-Range", state.ETag)
+		} else if state.LastModified != "" {
+			req.Header.Set("If-Range", state.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download file: %w", err)
+	}
+	body := newIdleTimeoutReader(resp.Body, downloadIdleTimeout)
+	defer body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			fmt.Println("Server does not support resuming, restarting download")
+		}
+		offset = 0
+	case http.StatusPartialContent:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			removePartFiles(outputPath)
+			return 0, fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+		}
+		if total > 0 {
+			state.TotalSize = total
+		}
+		fmt.Printf("Resuming download at %.2f MB\n", float64(offset)/(1024*1024))
+	case http.StatusRequestedRangeNotSatisfiable:
+		removePartFiles(outputPath)
+		return 0, fmt.Errorf("stale partial download discarded")
+	default:
+		return 0, &httpStatusError{StatusCode: resp.StatusCode}
+	}
+
+	state.URL = url
+	state.ETag = resp.Header.Get("ETag")
+	state.LastModified = resp.Header.Get("Last-Modified")
+	if resp.StatusCode == http.StatusOK {
+		state.TotalSize = resp.ContentLength
+		if state.TotalSize < 0 {
+			state.TotalSize = 0
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset > 0 {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	state.Offset = offset
+	if err := writePartState(statePath, state); err != nil {
+		out.Close()
+		return 0, fmt.Errorf("failed to write download state: %w", err)
+	}
+
+	pw := NewProgressWriterWithID(out, DownloadItemIDFromContext(ctx))
+	pw.total = offset
+	pw.lastPrinted = offset
+	pw.lastBytes = offset
+	pw.expected = state.TotalSize
+
+	_, copyErr := io.Copy(pw, body)
+	closeErr := out.Close()
+
+	state.Offset = pw.GetTotal()
+	writePartState(statePath, state)
+
+	if copyErr != nil {
+		return 0, fmt.Errorf("failed to write file: %w", copyErr)
+	}
+	if closeErr != nil {
+		return 0, fmt.Errorf("failed to write file: %w", closeErr)
+	}
+	if state.TotalSize > 0 && state.Offset != state.TotalSize {
+		return 0, fmt.Errorf("incomplete download: got %d of %d bytes", state.Offset, state.TotalSize)
+	}
+
+	return finishPart(partPath, statePath, outputPath, state.Offset)
+}
+
+func finishPart(partPath, statePath, outputPath string, size int64) (int64, error) {
+	if err := os.Rename(partPath, outputPath); err != nil {
+		return 0, fmt.Errorf("failed to move completed download into place: %w", err)
+	}
+	os.Remove(statePath)
+
+	fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(size)/(1024*1024))
+	return size, nil
+}
+
+// parseContentRange parses "bytes start-end/total". total is 0 when the
+// server reports it as unknown ("*").
+func parseContentRange(value string) (start, total int64, ok bool) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "bytes ") {
+		return 0, 0, false
+	}
+	value = strings.TrimPrefix(value, "bytes ")
+
+	rangePart, totalPart, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, false
+	}
+	startPart, _, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(strings.TrimSpace(startPart), 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if totalPart != "*" {
+		total, err = strconv.ParseInt(strings.TrimSpace(totalPart), 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
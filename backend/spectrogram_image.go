@@ -0,0 +1,399 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	spectrogramWidth        = 1200
+	spectrogramHeight       = 600
+	spectrogramDynamicRange = 120.0
+	spectrogramMarginLeft   = 80
+	spectrogramMarginRight  = 20
+	spectrogramMarginTop    = 20
+	spectrogramMarginBottom = 40
+	spectrogramFontScale    = 2
+	spectrogramTargetTicks  = 8
+)
+
+// Colormaps for SpectrogramImageOptions.Colormap.
+const (
+	ColormapInferno   = "inferno"
+	ColormapViridis   = "viridis"
+	ColormapGrayscale = "grayscale"
+)
+
+var (
+	spectrogramBackground = color.RGBA{0x12, 0x12, 0x16, 0xff}
+	spectrogramAxisColor  = color.RGBA{0xc8, 0xc8, 0xc8, 0xff}
+	spectrogramCutoff     = color.RGBA{0x00, 0xe5, 0xff, 0xff}
+
+	colormaps = map[string][]color.RGBA{
+		ColormapInferno: {
+			{0x00, 0x00, 0x04, 0xff}, {0x1f, 0x0c, 0x48, 0xff}, {0x55, 0x0f, 0x6d, 0xff},
+			{0x88, 0x22, 0x6a, 0xff}, {0xba, 0x36, 0x55, 0xff}, {0xe3, 0x59, 0x33, 0xff},
+			{0xf9, 0x8e, 0x09, 0xff}, {0xf6, 0xd7, 0x46, 0xff}, {0xfc, 0xff, 0xa4, 0xff},
+		},
+		ColormapViridis: {
+			{0x44, 0x01, 0x54, 0xff}, {0x47, 0x2d, 0x7b, 0xff}, {0x3b, 0x52, 0x8b, 0xff},
+			{0x2c, 0x72, 0x8e, 0xff}, {0x21, 0x91, 0x8c, 0xff}, {0x28, 0xae, 0x80, 0xff},
+			{0x5e, 0xc9, 0x62, 0xff}, {0xad, 0xdc, 0x30, 0xff}, {0xfd, 0xe7, 0x25, 0xff},
+		},
+		ColormapGrayscale: {
+			{0x00, 0x00, 0x00, 0xff}, {0xff, 0xff, 0xff, 0xff},
+		},
+	}
+)
+
+// SpectrogramImageOptions controls how a spectrogram is rendered. Width and
+// Height are the size of the whole image including axes. DynamicRange is how
+// many dB below the loudest bin map to the bottom of the colormap.
+type SpectrogramImageOptions struct {
+	Width        int             `json:"width,omitempty"`
+	Height       int             `json:"height,omitempty"`
+	Colormap     string          `json:"colormap,omitempty"`
+	DynamicRange float64         `json:"dynamic_range,omitempty"`
+	Spectrum     SpectrumOptions `json:"spectrum"`
+}
+
+func (o SpectrogramImageOptions) normalized() (SpectrogramImageOptions, error) {
+	if o.Width <= 0 {
+		o.Width = spectrogramWidth
+	}
+	if o.Height <= 0 {
+		o.Height = spectrogramHeight
+	}
+	if o.Width < spectrogramMarginLeft+spectrogramMarginRight+100 || o.Height < spectrogramMarginTop+spectrogramMarginBottom+100 {
+		return o, fmt.Errorf("spectrogram image is too small: %dx%d", o.Width, o.Height)
+	}
+
+	o.Colormap = strings.ToLower(o.Colormap)
+	if o.Colormap == "" {
+		o.Colormap = ColormapInferno
+	}
+	if _, ok := colormaps[o.Colormap]; !ok {
+		return o, fmt.Errorf("unknown colormap: %s", o.Colormap)
+	}
+
+	if o.DynamicRange <= 0 {
+		o.DynamicRange = spectrogramDynamicRange
+	}
+	return o, nil
+}
+
+// SpectrogramImage is a rendered spectrogram and what was detected while
+// computing it.
+type SpectrogramImage struct {
+	PNG       []byte            `json:"-"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Duration  float64           `json:"duration"`
+	MaxFreq   float64           `json:"max_freq"`
+	Transcode *TranscodeVerdict `json:"transcode,omitempty"`
+}
+
+// DataURL returns the PNG as a data: URL the frontend can use directly.
+func (s *SpectrogramImage) DataURL() string {
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(s.PNG)
+}
+
+// RenderSpectrogram analyzes path and renders its spectrogram to PNG, with
+// frequency and time axes and a line at the detected lowpass cutoff. The
+// cutoff is always detected on the default linear spectrum, computed in the
+// same pass when the requested spectrum differs.
+func RenderSpectrogram(path string, options SpectrogramImageOptions) (*SpectrogramImage, error) {
+	options, err := options.normalized()
+	if err != nil {
+		return nil, err
+	}
+	spectrumOptions, err := options.Spectrum.normalized()
+	if err != nil {
+		return nil, err
+	}
+
+	sink, _ := newSpectrumSink(spectrumOptions)
+	sinks := []audioSink{sink}
+
+	detect := sink
+	if defaults, _ := (SpectrumOptions{}).normalized(); spectrumOptions != defaults {
+		detect, _ = newSpectrumSink(SpectrumOptions{})
+		sinks = append(sinks, detect)
+	}
+
+	if _, err := analyzeFile(path, sinks...); err != nil {
+		sink.finish()
+		detect.finish()
+		return nil, err
+	}
+
+	data, err := sink.result()
+	if err != nil {
+		detect.finish()
+		return nil, err
+	}
+
+	var verdict *TranscodeVerdict
+	if detect == sink {
+		verdict = DetectLossySource(data)
+	} else if linear, err := detect.result(); err == nil {
+		verdict = DetectLossySource(linear)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, drawSpectrogram(data, verdict, options)); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+
+	return &SpectrogramImage{
+		PNG:       buf.Bytes(),
+		Width:     options.Width,
+		Height:    options.Height,
+		Duration:  data.Duration,
+		MaxFreq:   data.MaxFreq,
+		Transcode: verdict,
+	}, nil
+}
+
+// ExportSpectrogram renders path's spectrogram and writes it to outPath. An
+// empty outPath writes next to the audio file, and an existing directory
+// gets "<track name>.png". It returns the path written.
+func ExportSpectrogram(path, outPath string, options SpectrogramImageOptions) (string, error) {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".png"
+	if outPath == "" {
+		outPath = filepath.Join(filepath.Dir(path), strings.TrimSuffix(base, ".png")+".spectrogram.png")
+	} else if info, err := os.Stat(outPath); err == nil && info.IsDir() {
+		outPath = filepath.Join(outPath, base)
+	}
+
+	img, err := RenderSpectrogram(path, options)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := os.WriteFile(outPath, img.PNG, 0644); err != nil {
+		return "", fmt.Errorf("failed to write spectrogram: %w", err)
+	}
+	return outPath, nil
+}
+
+func drawSpectrogram(data *SpectrumData, verdict *TranscodeVerdict, options SpectrogramImageOptions) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, options.Width, options.Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{spectrogramBackground}, image.Point{}, draw.Src)
+
+	plot := image.Rect(
+		spectrogramMarginLeft,
+		spectrogramMarginTop,
+		options.Width-spectrogramMarginRight,
+		options.Height-spectrogramMarginBottom,
+	)
+
+	var maxDB = math.Inf(-1)
+	for _, slice := range data.TimeSlices {
+		for _, m := range slice.Magnitudes {
+			maxDB = math.Max(maxDB, m)
+		}
+	}
+	minDB := maxDB - options.DynamicRange
+	palette := colormaps[options.Colormap]
+
+	width, height := plot.Dx(), plot.Dy()
+	for x := 0; x < width; x++ {
+		slice := data.TimeSlices[x*len(data.TimeSlices)/width]
+		for y := 0; y < height; y++ {
+			bin := (height - 1 - y) * data.FreqBins / height
+			level := (slice.Magnitudes[bin] - minDB) / options.DynamicRange
+			img.SetRGBA(plot.Min.X+x, plot.Min.Y+y, colormapAt(palette, level))
+		}
+	}
+
+	freqY := func(hz float64) int {
+		return plot.Max.Y - 1 - int(spectrumPosition(data, hz)*float64(height-1))
+	}
+
+	// Frequency axis.
+	vline(img, plot.Min.X-1, plot.Min.Y, plot.Max.Y, spectrogramAxisColor)
+	lastY := math.MaxInt
+	for _, hz := range frequencyTicks(data) {
+		y := freqY(hz)
+		if lastY-y < 2*glyphHeight*spectrogramFontScale {
+			continue
+		}
+		lastY = y
+		hline(img, plot.Min.X-6, plot.Min.X-1, y, spectrogramAxisColor)
+		label := formatFrequency(hz)
+		drawText(img, plot.Min.X-8-textWidth(label), y-glyphHeight*spectrogramFontScale/2, label, spectrogramAxisColor)
+	}
+
+	// Time axis.
+	hline(img, plot.Min.X-1, plot.Max.X, plot.Max.Y, spectrogramAxisColor)
+	if data.Duration > 0 {
+		step := niceStep(data.Duration/spectrogramTargetTicks, []float64{1, 2, 5, 10, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600})
+		for t := 0.0; t <= data.Duration; t += step {
+			x := plot.Min.X + int(t/data.Duration*float64(width-1))
+			vline(img, x, plot.Max.Y, plot.Max.Y+6, spectrogramAxisColor)
+			label := formatTimestamp(t)
+			labelX := min(x-textWidth(label)/2, options.Width-textWidth(label)-2)
+			drawText(img, labelX, plot.Max.Y+10, label, spectrogramAxisColor)
+		}
+	}
+
+	if verdict != nil && verdict.LikelyLossy {
+		y := freqY(verdict.CutoffFreq)
+		for x := plot.Min.X; x < plot.Max.X; x++ {
+			if (x-plot.Min.X)%12 < 8 {
+				img.SetRGBA(x, y, spectrogramCutoff)
+			}
+		}
+		label := formatFrequency(verdict.CutoffFreq)
+		drawText(img, plot.Max.X-textWidth(label)-4, y-glyphHeight*spectrogramFontScale-4, label, spectrogramCutoff)
+	}
+
+	return img
+}
+
+// spectrumPosition is where hz falls on the spectrum's frequency axis, from
+// 0 at the bottom to 1 at Nyquist.
+func spectrumPosition(data *SpectrumData, hz float64) float64 {
+	if data.isLinear() {
+		return math.Min(math.Max(hz/data.MaxFreq, 0), 1)
+	}
+	toScale, _, low := spectrumScaleFuncs(data.Scale)
+	lo, hi := toScale(low), toScale(data.MaxFreq)
+	return math.Min(math.Max((toScale(math.Max(hz, low))-lo)/(hi-lo), 0), 1)
+}
+
+func frequencyTicks(data *SpectrumData) []float64 {
+	var ticks []float64
+	if data.isLinear() {
+		step := niceStep(data.MaxFreq/spectrogramTargetTicks, []float64{1000, 2000, 5000, 10000, 20000})
+		for hz := 0.0; hz <= data.MaxFreq; hz += step {
+			ticks = append(ticks, hz)
+		}
+		return ticks
+	}
+
+	_, _, low := spectrumScaleFuncs(data.Scale)
+	for _, hz := range []float64{50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000, 40000, 80000} {
+		if hz >= low && hz <= data.MaxFreq {
+			ticks = append(ticks, hz)
+		}
+	}
+	return ticks
+}
+
+// niceStep returns the first step at least as large as least, or the largest.
+func niceStep(least float64, steps []float64) float64 {
+	for _, step := range steps {
+		if step >= least {
+			return step
+		}
+	}
+	return steps[len(steps)-1]
+}
+
+func formatFrequency(hz float64) string {
+	if hz < 1000 {
+		return fmt.Sprintf("%.0f Hz", hz)
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", hz/1000), ".0") + " kHz"
+}
+
+func formatTimestamp(seconds float64) string {
+	total := int(seconds)
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
+
+// colormapAt interpolates palette at level, clamped to 0..1.
+func colormapAt(palette []color.RGBA, level float64) color.RGBA {
+	level = math.Min(math.Max(level, 0), 1)
+	pos := level * float64(len(palette)-1)
+	i := min(int(pos), len(palette)-2)
+	t := pos - float64(i)
+
+	a, b := palette[i], palette[i+1]
+	mix := func(x, y uint8) uint8 {
+		return uint8(float64(x) + (float64(y)-float64(x))*t + 0.5)
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
+
+func hline(img *image.RGBA, x0, x1, y int, c color.RGBA) {
+	for x := x0; x < x1; x++ {
+		img.SetRGBA(x, y, c)
+	}
+}
+
+func vline(img *image.RGBA, x, y0, y1 int, c color.RGBA) {
+	for y := y0; y < y1; y++ {
+		img.SetRGBA(x, y, c)
+	}
+}
+
+// A 5x7 bitmap font covering what the axis labels need, so rendering does
+// not depend on a font package.
+const (
+	glyphWidth   = 5
+	glyphHeight  = 7
+	glyphSpacing = 1
+)
+
+var glyphs = map[rune][glyphHeight]string{
+	'0': {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1': {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2': {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3': {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4': {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5': {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6': {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7': {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8': {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9': {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+	'.': {".....", ".....", ".....", ".....", ".....", ".##..", ".##.."},
+	':': {".....", ".##..", ".##..", ".....", ".##..", ".##..", "....."},
+	'k': {"#....", "#....", "#..#.", "#.#..", "##...", "#.#..", "#..#."},
+	'H': {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'z': {".....", ".....", "#####", "...#.", "..#..", ".#...", "#####"},
+	' ': {".....", ".....", ".....", ".....", ".....", ".....", "....."},
+}
+
+func textWidth(text string) int {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return (n*(glyphWidth+glyphSpacing) - glyphSpacing) * spectrogramFontScale
+}
+
+func drawText(img *image.RGBA, x, y int, text string, c color.RGBA) {
+	for _, r := range text {
+		glyph, ok := glyphs[r]
+		if ok {
+			for row, line := range glyph {
+				for col, bit := range line {
+					if bit != '#' {
+						continue
+					}
+					for dy := 0; dy < spectrogramFontScale; dy++ {
+						for dx := 0; dx < spectrogramFontScale; dx++ {
+							img.SetRGBA(x+col*spectrogramFontScale+dx, y+row*spectrogramFontScale+dy, c)
+						}
+					}
+				}
+			}
+		}
+		x += (glyphWidth + glyphSpacing) * spectrogramFontScale
+	}
+}
@@ -3,9 +3,9 @@ package backend
 import (
 	"fmt"
 	"math"
-	"math/cmplx"
-
-	"github.com/mewkiz/flac"
+	"runtime"
+	"strings"
+	"sync"
 )
 
 type SpectrumData struct {
@@ -14,6 +14,10 @@ type SpectrumData struct {
 	FreqBins   int         `json:"freq_bins"`
 	Duration   float64     `json:"duration"`
 	MaxFreq    float64     `json:"max_freq"`
+	// Scale is "linear", "log" or "mel". Linear bins are MaxFreq/FreqBins
+	// wide; for the other scales Frequencies holds each bin's centre.
+	Scale       string    `json:"scale,omitempty"`
+	Frequencies []float64 `json:"frequencies,omitempty"`
 }
 
 type TimeSlice struct {
@@ -21,161 +25,450 @@ type TimeSlice struct {
 	Magnitudes []float64 `json:"magnitudes"`
 }
 
-func AnalyzeSpectrum(filepath string) (*SpectrumData, error) {
+// Window functions and frequency scales accepted in SpectrumOptions.
+const (
+	SpectrumWindowHann           = "hann"
+	SpectrumWindowBlackmanHarris = "blackman_harris"
 
-	stream, err := flac.ParseFile(filepath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse FLAC: %w", err)
-	}
-	defer stream.Close()
+	SpectrumScaleLinear = "linear"
+	SpectrumScaleLog    = "log"
+	SpectrumScaleMel    = "mel"
+)
+
+const (
+	spectrumFFTSize      = 8192
+	spectrumTimeSlices   = 300
+	spectrumScaledBins   = 512
+	spectrumMinFFTSize   = 256
+	spectrumMaxFFTSize   = 65536
+	spectrumMaxOverlap   = 0.95
+	spectrumLogMinFreq   = 20.0
+	spectrumQueuedJobs   = 4
+	spectrumMinMagnitude = 1e-10
+)
 
-	info := stream.Info
-	sampleRate := int(info.SampleRate)
-	channels := int(info.NChannels)
+// SpectrumOptions shapes a spectrogram. Zero values give the defaults: an
+// 8192-point Hann window, no overlap, 300 time slices and a linear scale.
+type SpectrumOptions struct {
+	FFTSize    int     `json:"fft_size,omitempty"`
+	Window     string  `json:"window,omitempty"`
+	Overlap    float64 `json:"overlap,omitempty"`
+	TimeSlices int     `json:"time_slices,omitempty"`
+	Scale      string  `json:"scale,omitempty"`
+	// Bins is the number of output bins for the log and mel scales.
+	Bins int `json:"bins,omitempty"`
+}
 
-	samples, err := readSamples(stream, channels)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read samples: %w", err)
+func (o SpectrumOptions) normalized() (SpectrumOptions, error) {
+	if o.FFTSize == 0 {
+		o.FFTSize = spectrumFFTSize
+	}
+	if !isPowerOfTwo(o.FFTSize) || o.FFTSize < spectrumMinFFTSize || o.FFTSize > spectrumMaxFFTSize {
+		return o, fmt.Errorf("fft size must be a power of two between %d and %d", spectrumMinFFTSize, spectrumMaxFFTSize)
 	}
 
-	if len(samples) == 0 {
-		return nil, fmt.Errorf("no audio samples found")
+	o.Window = strings.ToLower(o.Window)
+	switch o.Window {
+	case "":
+		o.Window = SpectrumWindowHann
+	case SpectrumWindowHann, SpectrumWindowBlackmanHarris:
+	default:
+		return o, fmt.Errorf("unknown window function: %s", o.Window)
 	}
 
-	return calculateSpectrum(samples, sampleRate), nil
+	if o.Overlap < 0 || o.Overlap > spectrumMaxOverlap {
+		return o, fmt.Errorf("overlap must be between 0 and %.2f", spectrumMaxOverlap)
+	}
+	if o.TimeSlices <= 0 {
+		o.TimeSlices = spectrumTimeSlices
+	}
+
+	o.Scale = strings.ToLower(o.Scale)
+	switch o.Scale {
+	case "":
+		o.Scale = SpectrumScaleLinear
+	case SpectrumScaleLinear, SpectrumScaleLog, SpectrumScaleMel:
+	default:
+		return o, fmt.Errorf("unknown frequency scale: %s", o.Scale)
+	}
+	if o.Bins <= 0 {
+		o.Bins = spectrumScaledBins
+	}
+	return o, nil
 }
 
-func readSamples(stream *flac.Stream, channels int) ([]float64, error) {
-	var allSamples []float64
-	maxSamples := 10 * 1024 * 1024
+func AnalyzeSpectrum(filepath string) (*SpectrumData, error) {
+	return AnalyzeSpectrumWithOptions(filepath, SpectrumOptions{})
+}
 
-	for {
-		frame, err := stream.ParseNext()
-		if err != nil {
+// AnalyzeSpectrumWithOptions computes a spectrogram of the whole file.
+func AnalyzeSpectrumWithOptions(filepath string, options SpectrumOptions) (*SpectrumData, error) {
+	sink, err := newSpectrumSink(options)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := analyzeFile(filepath, sink); err != nil {
+		sink.finish()
+		return nil, err
+	}
+	return sink.result()
+}
 
-			break
-		}
+// spectrumSink computes a spectrogram of the mono mix as the stream goes by.
+// A window is taken every hop samples and transformed by a pool of workers,
+// and the power spectra are averaged into TimeSlices columns, so the whole
+// track is covered while only one window of samples is buffered. When the
+// source does not report its length, each column starts as one window and
+// neighbouring columns are merged pairwise whenever the stream outgrows them.
+// Samples are kept at the source's integer scale so the magnitudes read the
+// same whatever decoder produced them.
+type spectrumSink struct {
+	options SpectrumOptions
+	window  []float64
+	hop     int
+
+	started         bool
+	sampleRate      int
+	gain            float64
+	samplesPerSlice float64
+
+	position uint64
+	pending  []float64
+	// pendingStart is the stream position of pending[0].
+	pendingStart uint64
+
+	slices  []spectrumSlice
+	jobs    chan spectrumJob
+	buffers sync.Pool
+	workers sync.WaitGroup
+	done    bool
+}
 
-		for i := 0; i < frame.Subframes[0].NSamples; i++ {
-			var sample float64
+// spectrumSlice accumulates the power spectra of the windows falling in one
+// output column. start and used are only touched by the dispatching
+// goroutine; the rest is guarded by mu.
+type spectrumSlice struct {
+	start uint64
+	used  bool
 
-			for ch := 0; ch < channels; ch++ {
-				sample += float64(frame.Subframes[ch].Samples[i])
-			}
-			sample /= float64(channels)
+	mu    sync.Mutex
+	power []float64
+	count int
+}
 
-			allSamples = append(allSamples, sample)
+type spectrumJob struct {
+	slice   int
+	samples []float64
+}
 
-			if len(allSamples) >= maxSamples {
-				return allSamples, nil
-			}
-		}
+func newSpectrumSink(options SpectrumOptions) (*spectrumSink, error) {
+	options, err := options.normalized()
+	if err != nil {
+		return nil, err
 	}
 
-	return allSamples, nil
+	s := &spectrumSink{
+		options: options,
+		window:  spectrumWindow(options.Window, options.FFTSize),
+		hop:     max(1, int(float64(options.FFTSize)*(1-options.Overlap))),
+	}
+	s.buffers.New = func() any {
+		return make([]float64, options.FFTSize)
+	}
+	return s, nil
 }
 
-func calculateSpectrum(samples []float64, sampleRate int) *SpectrumData {
-	fftSize := 8192
-	numTimeSlices := 300
+func (s *spectrumSink) start(format audioFormat) {
+	s.started = true
+	s.sampleRate = format.SampleRate
 
-	duration := float64(len(samples)) / float64(sampleRate)
+	refBits := format.SourceBits
+	if refBits <= 0 {
+		refBits = 16
+	}
+	s.gain = math.Ldexp(1, refBits-format.BitsPerSample)
 
-	samplesPerSlice := len(samples) / numTimeSlices
-	if samplesPerSlice < fftSize {
-		samplesPerSlice = fftSize
-		numTimeSlices = len(samples) / fftSize
+	s.samplesPerSlice = float64(s.hop)
+	if format.TotalSamples > 0 {
+		s.samplesPerSlice = math.Max(float64(format.TotalSamples)/float64(s.options.TimeSlices), 1)
 	}
 
-	timeSlices := make([]TimeSlice, 0, numTimeSlices)
-	freqBins := fftSize / 2
-	maxFreq := float64(sampleRate) / 2.0
+	s.slices = make([]spectrumSlice, s.options.TimeSlices)
+	s.pending = make([]float64, 0, s.options.FFTSize)
+	s.startWorkers()
+}
+
+func (s *spectrumSink) startWorkers() {
+	workers := max(1, runtime.NumCPU())
+	s.jobs = make(chan spectrumJob, workers*spectrumQueuedJobs)
+	for i := 0; i < workers; i++ {
+		s.workers.Add(1)
+		go s.worker()
+	}
+}
 
-	for i := 0; i < numTimeSlices; i++ {
-		startIdx := i * samplesPerSlice
-		if startIdx+fftSize > len(samples) {
-			break
+// grow doubles the samples per column, merging each pair of columns into
+// one. The workers are drained first, since their jobs refer to columns by
+// index.
+func (s *spectrumSink) grow() {
+	close(s.jobs)
+	s.workers.Wait()
+
+	merged := make([]spectrumSlice, len(s.slices))
+	for i := range merged {
+		dst := &merged[i]
+		for _, j := range []int{2 * i, 2*i + 1} {
+			if j >= len(s.slices) || !s.slices[j].used {
+				continue
+			}
+			src := &s.slices[j]
+			if !dst.used {
+				dst.used = true
+				dst.start = src.start
+			}
+			if src.power != nil {
+				if dst.power == nil {
+					dst.power = src.power
+				} else {
+					for k, p := range src.power {
+						dst.power[k] += p
+					}
+				}
+			}
+			dst.count += src.count
 		}
+	}
+	s.slices = merged
+	s.samplesPerSlice *= 2
 
-		window := samples[startIdx : startIdx+fftSize]
+	s.startWorkers()
+}
 
-		windowedSamples := applyHannWindow(window)
+func (s *spectrumSink) worker() {
+	defer s.workers.Done()
 
-		spectrum := fft(windowedSamples)
+	n := s.options.FFTSize
+	plan := getFFTPlan(n)
+	x := make([]complex128, n)
+	power := make([]float64, n/2)
 
-		magnitudes := make([]float64, freqBins)
-		for j := 0; j < freqBins; j++ {
-			magnitude := cmplx.Abs(spectrum[j])
+	for job := range s.jobs {
+		for i, v := range job.samples {
+			x[i] = complex(v*s.window[i], 0)
+		}
+		s.buffers.Put(job.samples)
 
-			if magnitude < 1e-10 {
-				magnitude = 1e-10
-			}
-			magnitudes[j] = 20 * math.Log10(magnitude)
+		plan.transform(x)
+		for j := range power {
+			re, im := real(x[j]), imag(x[j])
+			power[j] = re*re + im*im
 		}
 
-		timeSlice := TimeSlice{
-			Time:       float64(startIdx) / float64(sampleRate),
-			Magnitudes: magnitudes,
+		slice := &s.slices[job.slice]
+		slice.mu.Lock()
+		if slice.power == nil {
+			slice.power = make([]float64, n/2)
+		}
+		for j, p := range power {
+			slice.power[j] += p
 		}
-		timeSlices = append(timeSlices, timeSlice)
+		slice.count++
+		slice.mu.Unlock()
+	}
+}
+
+func (s *spectrumSink) Write(block [][]int32, format audioFormat) {
+	if !s.started {
+		s.start(format)
 	}
 
-	return &SpectrumData{
-		TimeSlices: timeSlices,
-		SampleRate: sampleRate,
-		FreqBins:   freqBins,
-		Duration:   duration,
-		MaxFreq:    maxFreq,
+	channels := float64(len(block))
+	for i := range block[0] {
+		var sample float64
+		for ch := range block {
+			sample += float64(block[ch][i])
+		}
+		s.pending = append(s.pending, sample/channels*s.gain)
+		s.position++
+
+		if len(s.pending) == s.options.FFTSize {
+			s.dispatch()
+		}
 	}
 }
 
-func applyHannWindow(samples []float64) []float64 {
-	n := len(samples)
-	windowed := make([]float64, n)
+// dispatch queues the buffered window and slides it forward by hop.
+func (s *spectrumSink) dispatch() {
+	slice := int(float64(s.pendingStart) / s.samplesPerSlice)
+	for slice >= len(s.slices) {
+		s.grow()
+		slice = int(float64(s.pendingStart) / s.samplesPerSlice)
+	}
 
-	for i := 0; i < n; i++ {
-		window := 0.5 * (1.0 - math.Cos(2.0*math.Pi*float64(i)/float64(n-1)))
-		windowed[i] = samples[i] * window
+	if !s.slices[slice].used {
+		s.slices[slice].used = true
+		s.slices[slice].start = s.pendingStart
 	}
+	samples := s.buffers.Get().([]float64)
+	copy(samples, s.pending)
+	s.jobs <- spectrumJob{slice: slice, samples: samples}
+
+	if s.hop >= len(s.pending) {
+		s.pending = s.pending[:0]
+	} else {
+		s.pending = s.pending[:copy(s.pending, s.pending[s.hop:])]
+	}
+	s.pendingStart += uint64(s.hop)
+}
 
-	return windowed
+// finish stops the workers once the queued windows are done. It is safe to
+// call more than once.
+func (s *spectrumSink) finish() {
+	if !s.started || s.done {
+		return
+	}
+	s.done = true
+	close(s.jobs)
+	s.workers.Wait()
 }
 
-func fft(samples []float64) []complex128 {
-	n := len(samples)
+func (s *spectrumSink) result() (*SpectrumData, error) {
+	s.finish()
 
-	x := make([]complex128, n)
-	for i := 0; i < n; i++ {
-		x[i] = complex(samples[i], 0)
+	if s.position == 0 {
+		return nil, fmt.Errorf("no audio samples found")
+	}
+
+	data := &SpectrumData{
+		SampleRate: s.sampleRate,
+		Duration:   float64(s.position) / float64(s.sampleRate),
+		MaxFreq:    float64(s.sampleRate) / 2.0,
+		Scale:      s.options.Scale,
 	}
 
-	return fftRecursive(x)
+	var bands []spectrumBand
+	if s.options.Scale != SpectrumScaleLinear {
+		binHz := float64(s.sampleRate) / float64(s.options.FFTSize)
+		bands = spectrumBands(s.options.Scale, s.options.Bins, binHz, s.options.FFTSize/2)
+		data.Frequencies = make([]float64, len(bands))
+		for i, band := range bands {
+			data.Frequencies[i] = band.center
+		}
+	}
+
+	for i := range s.slices {
+		slice := &s.slices[i]
+		if slice.count == 0 {
+			continue
+		}
+
+		power := slice.power
+		for j := range power {
+			power[j] /= float64(slice.count)
+		}
+		if bands != nil {
+			power = bandPower(power, bands)
+		}
+
+		magnitudes := make([]float64, len(power))
+		for j, p := range power {
+			magnitudes[j] = 20 * math.Log10(math.Max(math.Sqrt(p), spectrumMinMagnitude))
+		}
+		data.TimeSlices = append(data.TimeSlices, TimeSlice{
+			Time:       float64(slice.start) / float64(s.sampleRate),
+			Magnitudes: magnitudes,
+		})
+	}
+	if len(data.TimeSlices) == 0 {
+		return nil, fmt.Errorf("audio is shorter than one FFT window")
+	}
+
+	data.FreqBins = len(data.TimeSlices[0].Magnitudes)
+	return data, nil
 }
 
-func fftRecursive(x []complex128) []complex128 {
-	n := len(x)
+// isLinear reports whether the bins are evenly spaced FFT bins, which is what
+// the lossy-source and upsampling checks expect.
+func (d *SpectrumData) isLinear() bool {
+	return d.Scale == "" || d.Scale == SpectrumScaleLinear
+}
 
-	if n <= 1 {
-		return x
+// spectrumWindow returns the window coefficients scaled to the coherent gain
+// of a Hann window, so levels read the same whichever window is used.
+func spectrumWindow(name string, n int) []float64 {
+	w := make([]float64, n)
+	var sum float64
+	for i := range w {
+		phase := 2 * math.Pi * float64(i) / float64(n-1)
+		switch name {
+		case SpectrumWindowBlackmanHarris:
+			w[i] = 0.35875 - 0.48829*math.Cos(phase) + 0.14128*math.Cos(2*phase) - 0.01168*math.Cos(3*phase)
+		default:
+			w[i] = 0.5 * (1.0 - math.Cos(phase))
+		}
+		sum += w[i]
 	}
 
-	even := make([]complex128, n/2)
-	odd := make([]complex128, n/2)
+	norm := 0.5 / (sum / float64(n))
+	for i := range w {
+		w[i] *= norm
+	}
+	return w
+}
+
+// spectrumBand is a range of FFT bins folded into one log or mel bin.
+type spectrumBand struct {
+	from, to int
+	center   float64
+}
+
+// spectrumBands splits the range up to Nyquist into count bands evenly
+// spaced on the log or mel scale. A band narrower than one FFT bin takes the
+// bin it falls in.
+func spectrumBands(scale string, count int, binHz float64, linearBins int) []spectrumBand {
+	nyquist := binHz * float64(linearBins)
+
+	toScale, fromScale, low := spectrumScaleFuncs(scale)
+	lo, hi := toScale(low), toScale(nyquist)
 
-	for i := 0; i < n/2; i++ {
-		even[i] = x[2*i]
-		odd[i] = x[2*i+1]
+	bands := make([]spectrumBand, count)
+	for i := range bands {
+		f0 := fromScale(lo + (hi-lo)*float64(i)/float64(count))
+		f1 := fromScale(lo + (hi-lo)*float64(i+1)/float64(count))
+
+		from := min(int(f0/binHz), linearBins-1)
+		to := min(max(int(f1/binHz), from+1), linearBins)
+		bands[i] = spectrumBand{from: from, to: to, center: (f0 + f1) / 2}
 	}
+	return bands
+}
 
-	evenFFT := fftRecursive(even)
-	oddFFT := fftRecursive(odd)
+// spectrumScaleFuncs returns the mapping from Hz onto a log or mel scale, its
+// inverse, and the lowest frequency the scale covers.
+func spectrumScaleFuncs(scale string) (toScale, fromScale func(float64) float64, low float64) {
+	if scale == SpectrumScaleLog {
+		return math.Log, math.Exp, spectrumLogMinFreq
+	}
+	return hzToMel, melToHz, 0
+}
 
-	result := make([]complex128, n)
-	for k := 0; k < n/2; k++ {
-		t := cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n))) * oddFFT[k]
-		result[k] = evenFFT[k] + t
-		result[k+n/2] = evenFFT[k] - t
+// bandPower averages the power of the FFT bins in each band.
+func bandPower(power []float64, bands []spectrumBand) []float64 {
+	out := make([]float64, len(bands))
+	for i, band := range bands {
+		var sum float64
+		for _, p := range power[band.from:band.to] {
+			sum += p
+		}
+		out[i] = sum / float64(band.to-band.from)
 	}
+	return out
+}
+
+func hzToMel(hz float64) float64 {
+	return 2595 * math.Log10(1+hz/700)
+}
 
-	return result
+func melToHz(mel float64) float64 {
+	return 700 * (math.Pow(10, mel/2595) - 1)
 }
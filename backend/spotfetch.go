@@ -1116,6 +1116,7 @@ func FilterPlaylist(data map[string]interface{}) map[string]interface{} {
 				"albumId":     albumID,
 				"duration":    durationString,
 				"is_explicit": isExplicit,
+				"added_at":    getString(getMap(itemMap, "addedAt"), "isoString"),
 			}
 			tracks = append(tracks, trackInfo)
 		}
@@ -81,6 +81,7 @@ type AlbumTrackMetadata struct {
 	Status      string         `json:"status,omitempty"`
 	PreviewURL  string         `json:"preview_url,omitempty"`
 	IsExplicit  bool           `json:"is_explicit,omitempty"`
+	AddedAt     string         `json:"added_at,omitempty"`
 }
 
 type TrackResponse struct {
@@ -245,6 +246,7 @@ type apiPlaylistResponse struct {
 		AlbumID     string   `json:"albumId"`
 		Duration    string   `json:"duration"`
 		IsExplicit  bool     `json:"is_explicit"`
+		AddedAt     string   `json:"added_at"`
 	} `json:"tracks"`
 }
 
@@ -379,7 +381,9 @@ func (c *SpotifyMetadataClient) getRawSpotifyData(ctx context.Context, parsed sp
 func (c *SpotifyMetadataClient) processSpotifyData(ctx context.Context, raw interface{}) (interface{}, error) {
 	switch payload := raw.(type) {
 	case *apiPlaylistResponse:
-		return c.formatPlaylistData(payload), nil
+		playlist := c.formatPlaylistData(payload)
+		recordPlaylistSnapshot(payload.ID, playlist)
+		return playlist, nil
 	case *apiAlbumResponse:
 		return c.formatAlbumData(payload)
 	case *apiTrackResponse:
@@ -986,6 +990,7 @@ func (c *SpotifyMetadataClient) formatPlaylistData(raw *apiPlaylistResponse) Pla
 			Plays:       item.Plays,
 			Status:      item.Status,
 			IsExplicit:  item.IsExplicit,
+			AddedAt:     item.AddedAt,
 		})
 	}
 
@@ -486,55 +486,29 @@ func contentTypeFromPath(p string) string {
 }
 
 func resolveRemoteStreamURL(spotifyID, isrc, audioFormat, provider string) (string, error) {
-	// Get provider URLs from SongLink API
-	client := NewSongLinkClient()
-	urls, err := client.GetAllURLsFromSpotify(spotifyID, "US")
-	if err != nil {
-		return "", fmt.Errorf("failed to resolve provider URLs: %w", err)
-	}
-
-	// Normalize provider preference
-	preferredProvider := strings.ToLower(strings.TrimSpace(provider))
-	
-	// Build provider priority list based on user preference
-	var providerOrder []string
-	switch preferredProvider {
-	case "amazon":
-		providerOrder = []string{"amazon", "tidal", "qobuz"}
-	case "qobuz":
-		providerOrder = []string{"qobuz", "tidal", "amazon"}
-	case "tidal":
-		providerOrder = []string{"tidal", "qobuz", "amazon"}
-	case "auto", "":
-		// Auto mode: try all providers in default order
-		providerOrder = []string{"tidal", "amazon", "qobuz"}
-	default:
-		// Unknown provider, use auto
-		providerOrder = []string{"tidal", "amazon", "qobuz"}
-	}
+	// Resolve provider links once and walk the same provider order that
+	// download fallback uses, with the requested provider first.
+	track := resolveServiceLinks(TrackRequest{SpotifyID: spotifyID, ISRC: isrc})
+	ctx := context.Background()
 
-	// Try each provider in priority order
 	var lastErr error
-	for _, prov := range providerOrder {
-		switch prov {
-		case "tidal":
-			if urls.TidalURL == "" {
-				continue
-			}
-			streamURL, err := tryTidalStream(urls.TidalURL, audioFormat)
-			if err == nil {
-				return streamURL, nil
-			}
-			lastErr = fmt.Errorf("tidal: %w", err)
+	for _, name := range ProviderOrder(strings.ToLower(strings.TrimSpace(provider)), nil) {
+		p, err := GetProvider(name)
+		if err != nil {
+			continue
+		}
 
-		case "amazon":
-			if urls.AmazonURL == "" {
-				continue
-			}
-			// Amazon streaming not yet implemented
-			lastErr = fmt.Errorf("amazon: streaming not yet implemented")
+		resolved, err := p.Resolve(ctx, track)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", name, err)
+			continue
+		}
 
+		streamURL, err := p.FetchStream(ctx, resolved, ProviderQuality(name, audioFormat))
+		if err == nil {
+			return streamURL, nil
 		}
+		lastErr = fmt.Errorf("%s: %w", name, err)
 	}
 
 	// All providers failed
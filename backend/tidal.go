@@ -13,6 +13,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -84,31 +85,24 @@ type TidalBTSManifest struct {
 }
 
 func NewTidalDownloader(apiURL string) *TidalDownloader {
-	clientID, _ := base64.StdEncoding.DecodeString("NkJEU1JkcEs5aHFFQlRnVQ==")
-	clientSecret, _ := base64.StdEncoding.DecodeString("eGV1UG1ZN25icFo5SUliTEFjUTkzc2hrYTFWTmhlVUFxTjZJY3N6alRHOD0=")
-
 	if apiURL == "" {
-		downloader := &TidalDownloader{
-			client: &http.Client{
-				Timeout: 5 * time.Second,
-			},
-			timeout:      5 * time.Second,
-			maxRetries:   3,
-			clientID:     string(clientID),
-			clientSecret: string(clientSecret),
-			apiURL:       "",
-		}
-
-		apis, err := downloader.GetAvailableAPIs()
+		apis, err := newTidalDownloader("").GetAvailableAPIs()
 		if err == nil && len(apis) > 0 {
 			apiURL = apis[0]
 		}
 	}
 
+	return newTidalDownloader(apiURL)
+}
+
+// newTidalDownloader builds a downloader for apiURL without probing for an
+// available API first.
+func newTidalDownloader(apiURL string) *TidalDownloader {
+	clientID, _ := base64.StdEncoding.DecodeString("NkJEU1JkcEs5aHFFQlRnVQ==")
+	clientSecret, _ := base64.StdEncoding.DecodeString("eGV1UG1ZN25icFo5SUliTEFjUTkzc2hrYTFWTmhlVUFxTjZJY3N6alRHOD0=")
+
 	return &TidalDownloader{
-		client: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+		client:       newAPIClient(5 * time.Second),
 		timeout:      5 * time.Second,
 		maxRetries:   3,
 		clientID:     string(clientID),
@@ -387,7 +381,7 @@ func (t *TidalDownloader) GetStreamURLWithFallback(trackID int64, quality string
 
 	for _, apiURL := range apis {
 		go func(api string) {
-			client := &http.Client{Timeout: 10 * time.Second}
+			client := newAPIClient(10 * time.Second)
 
 			// Try the requested quality first
 			url := fmt.Sprintf("%s/track/?id=%d&quality=%s", api, trackID, quality)
@@ -495,77 +489,37 @@ func (t *TidalDownloader) DownloadAlbumArt(albumID string) ([]byte, error) {
 	return io.ReadAll(resp.Body)
 }
 
-func (t *TidalDownloader) DownloadFile(url, filepath string) error {
+func (t *TidalDownloader) DownloadFile(ctx context.Context, url, filepath string) error {
 
 	if strings.HasPrefix(url, "MANIFEST:") {
-		return t.DownloadFromManifest(strings.TrimPrefix(url, "MANIFEST:"), filepath)
-	}
-
-	resp, err := t.client.Get(url)
-
-	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
+		return t.DownloadFromManifest(ctx, strings.TrimPrefix(url, "MANIFEST:"), filepath)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("download failed with status %d", resp.StatusCode)
+	if _, err := DownloadResumable(ctx, newDownloadClient(), url, filepath); err != nil {
+		return err
 	}
 
-	out, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer out.Close()
-
-	pw := NewProgressWriter(out)
-	_, err = io.Copy(pw, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
-	}
-
-	fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(pw.GetTotal())/(1024*1024))
-
 	fmt.Println("Download complete")
 	return nil
 }
 
-func (t *TidalDownloader) DownloadFromManifest(manifestB64, outputPath string) error {
+func (t *TidalDownloader) DownloadFromManifest(ctx context.Context, manifestB64, outputPath string) error {
+	trackAttemptFile(ctx, outputPath)
+
 	directURL, initURL, mediaURLs, err := parseManifest(manifestB64)
 	if err != nil {
 		return fmt.Errorf("failed to parse manifest: %w", err)
 	}
 
-	client := &http.Client{
-		Timeout: 120 * time.Second,
-	}
+	client := newDownloadClient()
 
 	if directURL != "" {
 		fmt.Println("Downloading file...")
 
-		resp, err := client.Get(directURL)
-		if err != nil {
-			return fmt.Errorf("failed to download file: %w", err)
+		if _, err := DownloadResumable(ctx, client, directURL, outputPath); err != nil {
+			return err
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("download failed with status %d", resp.StatusCode)
-		}
-
-		out, err := os.Create(outputPath)
-		if err != nil {
-			return fmt.Errorf("failed to create file: %w", err)
-		}
-		defer out.Close()
-
-		pw := NewProgressWriter(out)
-		_, err = io.Copy(pw, resp.Body)
-		if err != nil {
-			return fmt.Errorf("failed to write file: %w", err)
-		}
-
-		fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(pw.GetTotal())/(1024*1024))
 		fmt.Println("Download complete")
 		return nil
 	}
@@ -578,68 +532,10 @@ func (t *TidalDownloader) DownloadFromManifest(manifestB64, outputPath string) e
 		return fmt.Errorf("failed to create temp file: %w", err)
 	}
 
-	fmt.Print("Downloading init segment... ")
-	resp, err := client.Get(initURL)
-	if err != nil {
-		out.Close()
-		os.Remove(tempPath)
-		return fmt.Errorf("failed to download init segment: %w", err)
-	}
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		out.Close()
-		os.Remove(tempPath)
-		return fmt.Errorf("init segment download failed with status %d", resp.StatusCode)
-	}
-	_, err = io.Copy(out, resp.Body)
-	resp.Body.Close()
-	if err != nil {
+	if _, err := downloadDASHSegments(ctx, client, initURL, mediaURLs, out); err != nil {
 		out.Close()
 		os.Remove(tempPath)
-		return fmt.Errorf("failed to write init segment: %w", err)
-	}
-	fmt.Println("OK")
-
-	totalSegments := len(mediaURLs)
-	var totalBytes int64
-	lastTime := time.Now()
-	var lastBytes int64
-	for i, mediaURL := range mediaURLs {
-		resp, err := client.Get(mediaURL)
-		if err != nil {
-			out.Close()
-			os.Remove(tempPath)
-			return fmt.Errorf("failed to download segment %d: %w", i+1, err)
-		}
-		if resp.StatusCode != 200 {
-			resp.Body.Close()
-			out.Close()
-			os.Remove(tempPath)
-			return fmt.Errorf("segment %d download failed with status %d", i+1, resp.StatusCode)
-		}
-		n, err := io.Copy(out, resp.Body)
-		totalBytes += n
-		resp.Body.Close()
-		if err != nil {
-			out.Close()
-			os.Remove(tempPath)
-			return fmt.Errorf("failed to write segment %d: %w", i+1, err)
-		}
-
-		mbDownloaded := float64(totalBytes) / (1024 * 1024)
-		now := time.Now()
-		timeDiff := now.Sub(lastTime).Seconds()
-		var speedMBps float64
-		if timeDiff > 0.1 {
-			bytesDiff := float64(totalBytes - lastBytes)
-			speedMBps = (bytesDiff / (1024 * 1024)) / timeDiff
-			SetDownloadSpeed(speedMBps)
-			lastTime = now
-			lastBytes = totalBytes
-		}
-		SetDownloadProgress(mbDownloaded)
-
-		fmt.Printf("\rDownloading: %.2f MB (%d/%d segments)", mbDownloaded, i+1, totalSegments)
+		return err
 	}
 
 	out.Close()
@@ -657,11 +553,16 @@ func (t *TidalDownloader) DownloadFromManifest(manifestB64, outputPath string) e
 		return fmt.Errorf("invalid ffmpeg executable: %w", err)
 	}
 
-	cmd := exec.Command(ffmpegPath, "-y", "-i", tempPath, "-vn", "-c:a", "flac", outputPath)
+	cmd := exec.CommandContext(ctx, ffmpegPath, "-y", "-i", tempPath, "-vn", "-c:a", "flac", outputPath)
 	setHideWindow(cmd)
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			os.Remove(tempPath)
+			os.Remove(outputPath)
+			return fmt.Errorf("ffmpeg conversion stopped: %w", ctx.Err())
+		}
 
 		m4aPath := strings.TrimSuffix(outputPath, ".flac") + ".m4a"
 		os.Rename(tempPath, m4aPath)
@@ -674,112 +575,26 @@ func (t *TidalDownloader) DownloadFromManifest(manifestB64, outputPath string) e
 	return nil
 }
 
-func (t *TidalDownloader) DownloadByURL(tidalURL, outputDir, quality, filenameFormat string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate string, useAlbumTrackNumber bool, spotifyCoverURL string, embedMaxQualityCover bool, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string, allowFallback bool) (string, error) {
-	if outputDir != "." {
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			return "", fmt.Errorf("directory error: %w", err)
-		}
-	}
-
-	fmt.Printf("Using Tidal URL: %s\n", tidalURL)
-
-	trackID, err := t.GetTrackIDFromURL(tidalURL)
-	if err != nil {
-		return "", err
-	}
-
-	trackInfo, err := t.GetTrackInfoByID(trackID)
-	if err != nil {
-		return "", err
-	}
-
-	if trackInfo.ID == 0 {
-		return "", fmt.Errorf("no track ID found")
-	}
-
-	artistName := spotifyArtistName
-	trackTitle := spotifyTrackName
-	albumTitle := spotifyAlbumName
-
-	artistNameForFile := sanitizeFilename(artistName)
-	trackTitleForFile := sanitizeFilename(trackTitle)
-	albumTitleForFile := sanitizeFilename(albumTitle)
-	albumArtistForFile := sanitizeFilename(spotifyAlbumArtist)
-
-	filename := buildTidalFilename(trackTitleForFile, artistNameForFile, albumTitleForFile, albumArtistForFile, spotifyReleaseDate, trackInfo.TrackNumber, spotifyDiscNumber, filenameFormat, includeTrackNumber, position, useAlbumTrackNumber)
-	outputFilename := filepath.Join(outputDir, filename)
-
-	if fileInfo, err := os.Stat(outputFilename); err == nil && fileInfo.Size() > 0 {
-		fmt.Printf("File already exists: %s (%.2f MB)\n", outputFilename, float64(fileInfo.Size())/(1024*1024))
-		return "EXISTS:" + outputFilename, nil
-	}
-
-	downloadURL, err := t.GetDownloadURL(trackInfo.ID, quality)
-	if err != nil {
-		return "", err
-	}
-
-	fmt.Printf("Downloading to: %s\n", outputFilename)
-	if err := t.DownloadFile(downloadURL, outputFilename); err != nil {
-		return "", err
-	}
-
-	fmt.Println("Adding metadata...")
-
-	coverPath := ""
-
-	if spotifyCoverURL != "" {
-		coverPath = outputFilename + ".cover.jpg"
-		coverClient := NewCoverClient()
-		if err := coverClient.DownloadCoverToPath(spotifyCoverURL, coverPath, embedMaxQualityCover); err != nil {
-			fmt.Printf("Warning: Failed to download Spotify cover: %v\n", err)
-			coverPath = ""
-		} else {
-			defer os.Remove(coverPath)
-			fmt.Println("Spotify cover downloaded")
-		}
-	}
-
-	trackNumberToEmbed := spotifyTrackNumber
-	if trackNumberToEmbed == 0 {
-		trackNumberToEmbed = 1
-	}
-
-	metadata := Metadata{
-		Title:       trackTitle,
-		Artist:      artistName,
-		Album:       albumTitle,
-		AlbumArtist: spotifyAlbumArtist,
-		Date:        spotifyReleaseDate,
-		TrackNumber: trackNumberToEmbed,
-		TotalTracks: spotifyTotalTracks,
-		DiscNumber:  spotifyDiscNumber,
-		TotalDiscs:  spotifyTotalDiscs,
-		URL:         spotifyURL,
-		Copyright:   spotifyCopyright,
-		Publisher:   spotifyPublisher,
-		Description: "https://github.com/afkarxyz/SpotiFLAC",
-	}
-
-	if err := EmbedMetadata(outputFilename, metadata, coverPath); err != nil {
-		fmt.Printf("Tagging failed: %v\n", err)
-	} else {
-		fmt.Println("Metadata saved")
-	}
+func (t *TidalDownloader) DownloadByURL(ctx context.Context, tidalURL string, track TrackRequest, opts DownloadOptions) (string, error) {
+	return t.downloadByURL(ctx, tidalURL, track, opts, false)
+}
 
-	fmt.Println("Done")
-	fmt.Println("✓ Downloaded successfully from Tidal")
-	return outputFilename, nil
+func (t *TidalDownloader) DownloadByURLWithFallback(ctx context.Context, tidalURL string, track TrackRequest, opts DownloadOptions) (string, error) {
+	return t.downloadByURL(ctx, tidalURL, track, opts, true)
 }
 
-func (t *TidalDownloader) DownloadByURLWithFallback(tidalURL, outputDir, quality, filenameFormat string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate string, useAlbumTrackNumber bool, spotifyCoverURL string, embedMaxQualityCover bool, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string, allowFallback bool) (string, error) {
-	apis, err := t.GetAvailableAPIs()
-	if err != nil {
-		return "", fmt.Errorf("no APIs available for fallback: %w", err)
+func (t *TidalDownloader) downloadByURL(ctx context.Context, tidalURL string, track TrackRequest, opts DownloadOptions, fallback bool) (string, error) {
+	var apis []string
+	if fallback {
+		var err error
+		apis, err = t.GetAvailableAPIs()
+		if err != nil {
+			return "", fmt.Errorf("no APIs available for fallback: %w", err)
+		}
 	}
 
-	if outputDir != "." {
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
+	if opts.OutputDir != "." {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
 			return "", fmt.Errorf("directory error: %w", err)
 		}
 	}
@@ -800,72 +615,46 @@ func (t *TidalDownloader) DownloadByURLWithFallback(tidalURL, outputDir, quality
 		return "", fmt.Errorf("no track ID found")
 	}
 
-	artistName := spotifyArtistName
-	trackTitle := spotifyTrackName
-	albumTitle := spotifyAlbumName
+	artistNameForFile := sanitizeFilename(track.ArtistName)
+	trackTitleForFile := sanitizeFilename(track.TrackName)
+	albumTitleForFile := sanitizeFilename(track.AlbumName)
+	albumArtistForFile := sanitizeFilename(track.AlbumArtist)
 
-	artistNameForFile := sanitizeFilename(artistName)
-	trackTitleForFile := sanitizeFilename(trackTitle)
-	albumTitleForFile := sanitizeFilename(albumTitle)
-	albumArtistForFile := sanitizeFilename(spotifyAlbumArtist)
-
-	filename := buildTidalFilename(trackTitleForFile, artistNameForFile, albumTitleForFile, albumArtistForFile, spotifyReleaseDate, trackInfo.TrackNumber, spotifyDiscNumber, filenameFormat, includeTrackNumber, position, useAlbumTrackNumber)
-	outputFilename := filepath.Join(outputDir, filename)
+	filename := buildTidalFilename(trackTitleForFile, artistNameForFile, albumTitleForFile, albumArtistForFile, track.ReleaseDate, trackInfo.TrackNumber, track.DiscNumber, opts.FilenameFormat, opts.IncludeTrackNumber, opts.Position, opts.UseAlbumTrackNumber)
+	outputFilename := filepath.Join(opts.OutputDir, filename)
 
 	if fileInfo, err := os.Stat(outputFilename); err == nil && fileInfo.Size() > 0 {
 		fmt.Printf("File already exists: %s (%.2f MB)\n", outputFilename, float64(fileInfo.Size())/(1024*1024))
 		return "EXISTS:" + outputFilename, nil
 	}
 
-	successAPI, downloadURL, err := getDownloadURLParallel(apis, trackInfo.ID, quality)
-	if err != nil {
-		return "", err
+	downloader := t
+	var downloadURL string
+	if fallback {
+		var successAPI string
+		successAPI, downloadURL, err = getDownloadURLParallel(ctx, apis, trackInfo.ID, opts.Quality)
+		if err != nil {
+			return "", err
+		}
+		downloader = newTidalDownloader(successAPI)
+	} else {
+		downloadURL, err = t.GetDownloadURL(trackInfo.ID, opts.Quality)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	fmt.Printf("Downloading to: %s\n", outputFilename)
-	downloader := NewTidalDownloader(successAPI)
-	if err := downloader.DownloadFile(downloadURL, outputFilename); err != nil {
+	if err := downloader.DownloadFile(ctx, downloadURL, outputFilename); err != nil {
 		return "", err
 	}
 
 	fmt.Println("Adding metadata...")
 
-	coverPath := ""
-
-	if spotifyCoverURL != "" {
-		coverPath = outputFilename + ".cover.jpg"
-		coverClient := NewCoverClient()
-		if err := coverClient.DownloadCoverToPath(spotifyCoverURL, coverPath, embedMaxQualityCover); err != nil {
-			fmt.Printf("Warning: Failed to download Spotify cover: %v\n", err)
-			coverPath = ""
-		} else {
-			defer os.Remove(coverPath)
-			fmt.Println("Spotify cover downloaded")
-		}
-	}
-
-	trackNumberToEmbed := spotifyTrackNumber
-	if trackNumberToEmbed == 0 {
-		trackNumberToEmbed = 1
+	if !IsValidISRC(track.ISRC) && IsValidISRC(trackInfo.ISRC) {
+		track.ISRC = trackInfo.ISRC
 	}
-
-	metadata := Metadata{
-		Title:       trackTitle,
-		Artist:      artistName,
-		Album:       albumTitle,
-		AlbumArtist: spotifyAlbumArtist,
-		Date:        spotifyReleaseDate,
-		TrackNumber: trackNumberToEmbed,
-		TotalTracks: spotifyTotalTracks,
-		DiscNumber:  spotifyDiscNumber,
-		TotalDiscs:  spotifyTotalDiscs,
-		URL:         spotifyURL,
-		Copyright:   spotifyCopyright,
-		Publisher:   spotifyPublisher,
-		Description: "https://github.com/afkarxyz/SpotiFLAC",
-	}
-
-	if err := EmbedMetadata(outputFilename, metadata, coverPath); err != nil {
+	if err := embedTrackMetadata(outputFilename, track, opts); err != nil {
 		fmt.Printf("Tagging failed: %v\n", err)
 	} else {
 		fmt.Println("Metadata saved")
@@ -876,14 +665,14 @@ func (t *TidalDownloader) DownloadByURLWithFallback(tidalURL, outputDir, quality
 	return outputFilename, nil
 }
 
-func (t *TidalDownloader) Download(spotifyTrackID, outputDir, quality, filenameFormat string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate string, useAlbumTrackNumber bool, spotifyCoverURL string, embedMaxQualityCover bool, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string, allowFallback bool) (string, error) {
+func (t *TidalDownloader) Download(ctx context.Context, track TrackRequest, opts DownloadOptions) (string, error) {
 
-	tidalURL, err := t.GetTidalURLFromSpotify(spotifyTrackID)
+	tidalURL, err := t.GetTidalURLFromSpotify(track.SpotifyID)
 	if err != nil {
 		return "", fmt.Errorf("songlink couldn't find Tidal URL: %w", err)
 	}
 
-	return t.DownloadByURLWithFallback(tidalURL, outputDir, quality, filenameFormat, includeTrackNumber, position, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate, useAlbumTrackNumber, spotifyCoverURL, embedMaxQualityCover, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks, spotifyTotalDiscs, spotifyCopyright, spotifyPublisher, spotifyURL, allowFallback)
+	return t.DownloadByURLWithFallback(ctx, tidalURL, track, opts)
 }
 
 type SegmentTemplate struct {
@@ -1054,7 +843,7 @@ type manifestResult struct {
 	err      error
 }
 
-func getDownloadURLParallel(apis []string, trackID int64, quality string) (string, string, error) {
+func getDownloadURLParallel(ctx context.Context, apis []string, trackID int64, quality string) (string, string, error) {
 	if len(apis) == 0 {
 		return "", "", fmt.Errorf("no APIs available")
 	}
@@ -1065,12 +854,15 @@ func getDownloadURLParallel(apis []string, trackID int64, quality string) (strin
 	for _, apiURL := range apis {
 		go func(api string) {
 
-			client := &http.Client{
-				Timeout: 15 * time.Second,
-			}
+			client := newAPIClient(15 * time.Second)
 
 			url := fmt.Sprintf("%s/track/?id=%d&quality=%s", api, trackID, quality)
-			resp, err := client.Get(url)
+			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+			if err != nil {
+				resultChan <- manifestResult{apiURL: api, err: err}
+				return
+			}
+			resp, err := client.Do(req)
 			if err != nil {
 				resultChan <- manifestResult{apiURL: api, err: err}
 				return
@@ -1194,3 +986,74 @@ func buildTidalFilename(title, artist, album, albumArtist, releaseDate string, t
 
 	return filename + ".flac"
 }
+
+type tidalProvider struct{}
+
+func (tidalProvider) Name() string { return "tidal" }
+
+func (tidalProvider) Resolve(ctx context.Context, track TrackRequest) (*ResolvedTrack, error) {
+	tidalURL := track.serviceURLFor("tidal", "tidal.com")
+	if tidalURL == "" {
+		if track.ServiceURLs != nil {
+			return nil, fmt.Errorf("track not available on Tidal")
+		}
+		if track.SpotifyID == "" {
+			return nil, fmt.Errorf("spotify ID is required for Tidal")
+		}
+		var err error
+		tidalURL, err = newTidalDownloader("").GetTidalURLFromSpotify(track.SpotifyID)
+		if err != nil {
+			return nil, fmt.Errorf("songlink couldn't find Tidal URL: %w", err)
+		}
+	}
+
+	trackID, err := extractTidalTrackID(tidalURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Tidal URL format: %w", err)
+	}
+
+	return &ResolvedTrack{
+		Provider: "tidal",
+		URL:      tidalURL,
+		TrackID:  strconv.FormatInt(trackID, 10),
+		ISRC:     track.ISRC,
+	}, nil
+}
+
+func (tidalProvider) ProbeQuality(ctx context.Context, resolved *ResolvedTrack) (*QualityInfo, error) {
+	trackID, err := strconv.ParseInt(resolved.TrackID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Tidal track ID %q", resolved.TrackID)
+	}
+
+	trackInfo, err := newTidalDownloader("").GetTrackInfoByID(trackID)
+	if err != nil {
+		return nil, err
+	}
+
+	qualities := append([]string{trackInfo.AudioQuality}, trackInfo.MediaMetadata.Tags...)
+	var info *QualityInfo
+	for _, quality := range qualities {
+		switch strings.ToUpper(quality) {
+		case "HI_RES_LOSSLESS", "HIRES_LOSSLESS", "HI_RES":
+			return &QualityInfo{Lossless: true, BitDepth: 24}, nil
+		case "LOSSLESS":
+			info = &QualityInfo{Lossless: true, BitDepth: 16, SampleRate: 44100}
+		}
+	}
+	if info == nil {
+		return nil, ErrQualityUnknown
+	}
+	return info, nil
+}
+
+func (tidalProvider) FetchStream(ctx context.Context, resolved *ResolvedTrack, quality string) (string, error) {
+	return tryTidalStream(resolved.URL, quality)
+}
+
+func (tidalProvider) Download(ctx context.Context, resolved *ResolvedTrack, track TrackRequest, opts DownloadOptions) (string, error) {
+	if opts.ApiURL == "" || opts.ApiURL == "auto" {
+		return NewTidalDownloader("").DownloadByURLWithFallback(ctx, resolved.URL, track, opts)
+	}
+	return NewTidalDownloader(opts.ApiURL).DownloadByURL(ctx, resolved.URL, track, opts)
+}
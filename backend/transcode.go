@@ -0,0 +1,185 @@
+package backend
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// transcodeSmoothingHz is the width of the moving average applied to the
+	// averaged spectrum before looking for a cutoff.
+	transcodeSmoothingHz = 100.0
+	// transcodeContentDB is how far above the noise floor a band has to be to
+	// count as carrying audio.
+	transcodeContentDB = 15.0
+	// transcodeShelfDB is the drop across the cutoff that makes it a lowpass
+	// shelf rather than a natural roll-off.
+	transcodeShelfDB = 25.0
+	// transcodeMaxCutoffHz is the highest cutoff still treated as a lossy
+	// encoder's lowpass. Lossless masters reach 20 kHz and beyond.
+	transcodeMaxCutoffHz = 20500.0
+)
+
+// TranscodeVerdict describes where a file's spectrum ends and whether that
+// looks like a lossy encoder's lowpass.
+type TranscodeVerdict struct {
+	CutoffFreq   float64 `json:"cutoff_freq"`
+	Nyquist      float64 `json:"nyquist"`
+	ShelfDrop    float64 `json:"shelf_drop"`
+	LikelyLossy  bool    `json:"likely_lossy"`
+	LikelySource string  `json:"likely_source,omitempty"`
+	Confidence   float64 `json:"confidence"`
+}
+
+// lossySources maps the highest cutoff an encoder setting typically leaves
+// to a description of it. Cutoffs are those of common LAME, AAC and Vorbis
+// presets.
+var lossySources = []struct {
+	maxCutoff float64
+	source    string
+}{
+	{11500, "lossy ≤ 64 kbps"},
+	{15500, "MP3 ~96–112 kbps"},
+	{16500, "MP3/AAC ~128 kbps"},
+	{17800, "MP3 ~160 kbps"},
+	{19300, "MP3 ~192 kbps / AAC ~160 kbps"},
+	{transcodeMaxCutoffHz, "MP3/AAC/Vorbis 256–320 kbps"},
+}
+
+// DetectLossySource looks for the hard high-frequency shelf that MP3, AAC and
+// Vorbis encoders leave behind. The time slices are averaged by power, the
+// cutoff is the highest frequency still clearly above the noise floor, and
+// the file is flagged when the level falls off a cliff right above it.
+func DetectLossySource(spectrum *SpectrumData) *TranscodeVerdict {
+	if spectrum == nil || !spectrum.isLinear() || len(spectrum.TimeSlices) == 0 || spectrum.FreqBins == 0 {
+		return nil
+	}
+
+	binHz := spectrum.MaxFreq / float64(spectrum.FreqBins)
+	level := smoothLevels(averageSpectrum(spectrum), int(transcodeSmoothingHz/binHz))
+
+	verdict := &TranscodeVerdict{
+		Nyquist:    spectrum.MaxFreq,
+		CutoffFreq: spectrum.MaxFreq,
+	}
+
+	floor := percentile(level, 0.05)
+	cutoffBin := len(level) - 1
+	for cutoffBin > 0 && level[cutoffBin] < floor+transcodeContentDB {
+		cutoffBin--
+	}
+	verdict.CutoffFreq = float64(cutoffBin+1) * binHz
+
+	below := bandMean(level, verdict.CutoffFreq-1000, verdict.CutoffFreq-250, binHz)
+	above := bandMean(level, verdict.CutoffFreq+250, verdict.CutoffFreq+1000, binHz)
+	if !math.IsNaN(below) && !math.IsNaN(above) {
+		verdict.ShelfDrop = below - above
+	}
+
+	if verdict.ShelfDrop >= transcodeShelfDB &&
+		verdict.CutoffFreq < transcodeMaxCutoffHz &&
+		verdict.CutoffFreq < 0.95*verdict.Nyquist {
+		verdict.LikelyLossy = true
+		verdict.Confidence = math.Min(1, verdict.ShelfDrop/(2*transcodeShelfDB))
+		for _, s := range lossySources {
+			if verdict.CutoffFreq <= s.maxCutoff {
+				verdict.LikelySource = s.source
+				break
+			}
+		}
+	}
+
+	return verdict
+}
+
+// averageSpectrum averages the dB magnitudes of every time slice by power, so
+// quiet passages do not pull the high bands down.
+func averageSpectrum(spectrum *SpectrumData) []float64 {
+	avg := make([]float64, spectrum.FreqBins)
+	for _, slice := range spectrum.TimeSlices {
+		for j := 0; j < len(avg) && j < len(slice.Magnitudes); j++ {
+			avg[j] += math.Pow(10, slice.Magnitudes[j]/10)
+		}
+	}
+	for j := range avg {
+		avg[j] = 10 * math.Log10(avg[j]/float64(len(spectrum.TimeSlices))+1e-20)
+	}
+	return avg
+}
+
+func smoothLevels(levels []float64, width int) []float64 {
+	if width < 1 {
+		return levels
+	}
+	smoothed := make([]float64, len(levels))
+	for i := range levels {
+		lo, hi := max(0, i-width/2), min(len(levels), i+width/2+1)
+		var sum float64
+		for _, v := range levels[lo:hi] {
+			sum += v
+		}
+		smoothed[i] = sum / float64(hi-lo)
+	}
+	return smoothed
+}
+
+func percentile(values []float64, p float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// bandMean is the mean level between two frequencies, or NaN when the band
+// lies outside the spectrum.
+func bandMean(levels []float64, fromHz, toHz, binHz float64) float64 {
+	from, to := max(0, int(fromHz/binHz)), min(len(levels), int(toHz/binHz)+1)
+	if from >= to {
+		return math.NaN()
+	}
+	var sum float64
+	for _, v := range levels[from:to] {
+		sum += v
+	}
+	return sum / float64(to-from)
+}
+
+// TranscodeScanResult is one file's entry in a ScanForTranscodes report.
+type TranscodeScanResult struct {
+	FilePath string            `json:"file_path"`
+	Verdict  *TranscodeVerdict `json:"verdict,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// ScanForTranscodes runs DetectLossySource on every FLAC file under dir.
+func ScanForTranscodes(dir string) ([]TranscodeScanResult, error) {
+	var results []TranscodeScanResult
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".flac") {
+			return nil
+		}
+
+		result := TranscodeScanResult{FilePath: path}
+		spectrum, err := AnalyzeSpectrum(path)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Verdict = DetectLossySource(spectrum)
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return results, fmt.Errorf("failed to scan %s: %w", dir, err)
+	}
+
+	return results, nil
+}
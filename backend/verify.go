@@ -0,0 +1,148 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	mewflac "github.com/mewkiz/flac"
+)
+
+// What happens when a download fails verification.
+const (
+	// VerificationFail removes the file and fails the download. In auto mode
+	// the next provider is tried.
+	VerificationFail = "fail"
+	// VerificationFlag keeps the file and marks the item as unverified.
+	VerificationFlag = "flag"
+)
+
+// defaultDurationTolerance is how far, in seconds, a file's length may be
+// from Spotify's before it counts as a different version of the track.
+const defaultDurationTolerance = 5.0
+
+// Verification controls the post-download check: the whole FLAC is decoded,
+// frame CRCs and the STREAMINFO MD5 are checked, and the length is compared
+// with Spotify's duration.
+type Verification struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// DurationTolerance is in seconds. Zero uses defaultDurationTolerance.
+	DurationTolerance float64 `json:"duration_tolerance,omitempty"`
+	OnMismatch        string  `json:"on_mismatch,omitempty"`
+	// RetryOtherProviders lets an explicitly chosen service fall back to the
+	// remaining providers when its file fails verification.
+	RetryOtherProviders bool `json:"retry_other_providers,omitempty"`
+}
+
+func (v Verification) flagOnly() bool {
+	return v.OnMismatch == VerificationFlag
+}
+
+func (v Verification) tolerance() float64 {
+	if v.DurationTolerance > 0 {
+		return v.DurationTolerance
+	}
+	return defaultDurationTolerance
+}
+
+// VerificationError is returned when a downloaded file fails verification
+// and verification is set to fail.
+type VerificationError struct {
+	Problem string
+}
+
+func (e *VerificationError) Error() string {
+	return "verification failed: " + e.Problem
+}
+
+// VerifyDownload fully decodes the FLAC at path and describes what is wrong
+// with it: a decode error (usually truncation), a sample count or MD5 that
+// does not match STREAMINFO, or a duration more than tolerance seconds away
+// from expectedMS. expectedMS of 0 skips the duration check. It returns an
+// empty string when the file is fine.
+func VerifyDownload(path string, expectedMS int, tolerance float64) (string, error) {
+	stream, err := mewflac.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open FLAC: %w", err)
+	}
+	defer stream.Close()
+
+	info := stream.Info
+	md5sum := md5.New()
+	var decoded uint64
+	var problems []string
+
+	for {
+		frame, err := stream.ParseNext()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				problems = append(problems, fmt.Sprintf("decode error after %s: %v", formatSeconds(float64(decoded)/float64(info.SampleRate)), err))
+			}
+			break
+		}
+		frame.Hash(md5sum)
+		decoded += uint64(frame.Subframes[0].NSamples)
+	}
+
+	if len(problems) == 0 {
+		if info.NSamples > 0 && decoded != info.NSamples {
+			problems = append(problems, fmt.Sprintf("decoded %d of %d samples", decoded, info.NSamples))
+		}
+		var unset [md5.Size]byte
+		if info.MD5sum != unset && !bytes.Equal(md5sum.Sum(nil), info.MD5sum[:]) {
+			problems = append(problems, "audio MD5 does not match STREAMINFO")
+		}
+	}
+
+	if expectedMS > 0 && info.SampleRate > 0 {
+		actual := float64(decoded) / float64(info.SampleRate)
+		expected := float64(expectedMS) / 1000
+		if math.Abs(actual-expected) > tolerance {
+			problems = append(problems, fmt.Sprintf("duration %s, expected %s", formatSeconds(actual), formatSeconds(expected)))
+		}
+	}
+
+	return strings.Join(problems, ", "), nil
+}
+
+func formatSeconds(seconds float64) string {
+	s := int(math.Round(seconds))
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// CheckVerification runs VerifyDownload with v's tolerance. A file that
+// cannot be opened as FLAC is reported as a problem rather than an error.
+func CheckVerification(path string, expectedMS int, v Verification) string {
+	problem, err := VerifyDownload(path, expectedMS, v.tolerance())
+	if err != nil {
+		return err.Error()
+	}
+	return problem
+}
+
+// enforceVerification applies fail-mode verification to a freshly downloaded
+// file, removing it when it fails. Flag-mode verification is left to the
+// caller.
+func enforceVerification(path string, expectedMS int, v Verification) error {
+	if !v.Enabled || v.flagOnly() || path == "" || strings.HasPrefix(path, "EXISTS:") {
+		return nil
+	}
+	if !strings.HasSuffix(strings.ToLower(path), ".flac") {
+		return nil
+	}
+
+	problem := CheckVerification(path, expectedMS, v)
+	if problem == "" {
+		fmt.Println("Download verified")
+		return nil
+	}
+
+	fmt.Printf("Download failed verification (%s), removing %s\n", problem, path)
+	os.Remove(path)
+	return &VerificationError{Problem: problem}
+}